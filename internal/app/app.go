@@ -2,12 +2,22 @@ package app
 
 import (
 	"context"
+	"errors"
 	"ikoyhn/podcast-sponsorblock/internal/config"
 	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/services/downloader"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
 	"ikoyhn/podcast-sponsorblock/internal/services/youtube"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/labstack/echo/v4"
+	log "github.com/labstack/gommon/log"
 	"github.com/lrstanley/go-ytdlp"
+	"github.com/robfig/cron"
 )
 
 func Start() {
@@ -15,18 +25,66 @@ func Start() {
 	if _, err := config.Load(); err != nil {
 		panic(err)
 	}
+	if err := storage.Setup(); err != nil {
+		panic(err)
+	}
 	youtube.SetupYoutubeService()
 	ytdlp.MustInstallAll(context.TODO())
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	e := echo.New()
 	e.HideBanner = true
 	setupLogging(e)
 
 	database.SetupDatabase()
 	database.TrackEpisodeFiles()
+	downloader.StartDownloadQueue(ctx)
+	downloader.NormalizeCachedEpisodes()
 
-	setupCron()
+	c := setupCron()
 
 	setupHandlers(e)
 	registerRoutes(e)
+
+	go func() {
+		address := serverAddress()
+		log.Debug("Starting server on " + address)
+		if err := e.Start(address); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	shutdown(e, c)
+}
+
+// shutdown stops the server and the downloads within the configured shutdown
+// timeout. Requests in flight and running downloads are given time to finish
+// side by side, downloads only when configured to.
+func shutdown(e *echo.Echo, c *cron.Cron) {
+	timeout := config.ShutdownTimeout()
+	log.Infof("Shutting down (timeout %s)...", timeout)
+	c.Stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		downloader.StopDownloadQueue(config.AppConfig.Ytdlp.ShutdownDownloads == "wait", timeout)
+	}()
+
+	serverCtx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		serverCtx, cancel = context.WithTimeout(serverCtx, timeout)
+		defer cancel()
+	}
+	if err := e.Shutdown(serverCtx); err != nil {
+		log.Warnf("Server didn't shut down cleanly: %v", err)
+	}
+	wg.Wait()
+	log.Info("Shutdown complete")
 }
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/rss"
+	"ikoyhn/podcast-sponsorblock/internal/services/youtube"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// feedNotModified reports whether the client already has the current version
+// of the feed. It never triggers a YouTube refresh: when a refresh is due the
+// feed has to be rebuilt anyway, so it returns false.
+func feedNotModified(c echo.Context, podcastId string, params *models.RssRequestParams) bool {
+	if c.Request().Header.Get("If-None-Match") == "" && c.Request().Header.Get("If-Modified-Since") == "" {
+		return false
+	}
+	if youtube.ShouldRefresh(database.GetPodcast(podcastId)) {
+		return false
+	}
+
+	etag, lastModified, ok := rss.FeedValidators(podcastId, validatorQuery(c, params))
+	if !ok {
+		return false
+	}
+	setFeedValidators(c, etag, lastModified)
+	return isNotModified(c.Request(), etag, lastModified)
+}
+
+// writeFeedValidators sets the ETag and Last-Modified headers for the feed
+// that is about to be sent.
+func writeFeedValidators(c echo.Context, podcastId string, params *models.RssRequestParams) {
+	etag, lastModified, ok := rss.FeedValidators(podcastId, validatorQuery(c, params))
+	if ok {
+		setFeedValidators(c, etag, lastModified)
+	}
+}
+
+// setFeedValidators sets the validators on a feed response, including 304s.
+// The feed format can be picked through the Accept header, so caches have to
+// key on it too.
+func setFeedValidators(c echo.Context, etag string, lastModified time.Time) {
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	c.Response().Header().Set("Vary", "Accept")
+}
+
+// validatorQuery returns the request query with the negotiated format filled
+// in, so feeds picked through the Accept header get their own ETag.
+func validatorQuery(c echo.Context, params *models.RssRequestParams) url.Values {
+	query := c.Request().URL.Query()
+	query.Set("format", string(params.Format))
+	return query
+}
+
+// isNotModified follows RFC 9110: If-None-Match takes precedence and
+// If-Modified-Since is only evaluated when it is absent.
+func isNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !lastModified.After(since)
+	}
+	return false
+}
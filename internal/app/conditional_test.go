@@ -0,0 +1,39 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsNotModified(t *testing.T) {
+	etag := `"abc123"`
+	lastModified := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"no validators", nil, false},
+		{"matching etag", map[string]string{"If-None-Match": `"abc123"`}, true},
+		{"weak matching etag in list", map[string]string{"If-None-Match": `"old", W/"abc123"`}, true},
+		{"wildcard", map[string]string{"If-None-Match": "*"}, true},
+		{"stale etag", map[string]string{"If-None-Match": `"old"`}, false},
+		{"stale etag wins over date", map[string]string{"If-None-Match": `"old"`, "If-Modified-Since": lastModified.Format(http.TimeFormat)}, false},
+		{"same date", map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}, true},
+		{"older date", map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)}, false},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, false},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/rss/abc", nil)
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		if got := isNotModified(r, etag, lastModified); got != tc.want {
+			t.Errorf("%s: isNotModified = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
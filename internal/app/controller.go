@@ -1,14 +1,26 @@
 package app
 
 import (
+	"encoding/json"
+	"errors"
 	"ikoyhn/podcast-sponsorblock/internal/config"
 	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
 	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/autodownload"
 	"ikoyhn/podcast-sponsorblock/internal/services/channel"
+	"ikoyhn/podcast-sponsorblock/internal/services/chapters"
 	"ikoyhn/podcast-sponsorblock/internal/services/common"
 	"ikoyhn/podcast-sponsorblock/internal/services/downloader"
+	"ikoyhn/podcast-sponsorblock/internal/services/generator"
+	"ikoyhn/podcast-sponsorblock/internal/services/opml"
 	"ikoyhn/podcast-sponsorblock/internal/services/playlist"
+	"ikoyhn/podcast-sponsorblock/internal/services/recheck"
+	"ikoyhn/podcast-sponsorblock/internal/services/rss"
 	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
+	"ikoyhn/podcast-sponsorblock/internal/services/transcript"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,6 +31,7 @@ import (
 	"github.com/labstack/echo/v4"
 	log "github.com/labstack/gommon/log"
 	"github.com/robfig/cron"
+	"gorm.io/gorm"
 )
 
 func registerRoutes(e *echo.Echo) {
@@ -26,87 +39,757 @@ func registerRoutes(e *echo.Echo) {
 		if err := checkAuthentication(c); err != nil {
 			return err
 		}
-		rssRequestParams := validateQueryParams(c)
-		data := channel.BuildChannelRssFeed(c.Param("channelId"), rssRequestParams, handler(c.Request()))
-		c.Response().Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
-		c.Response().Header().Set("Content-Length", strconv.Itoa(len(data)))
-		c.Response().Header().Del("Transfer-Encoding")
-		return c.Blob(http.StatusOK, "application/rss+xml; charset=utf-8", data)
+		rssRequestParams, err := feedRequestParams(c)
+		if err != nil {
+			return err
+		}
+		channelId := c.Param("channelId")
+		if feedNotModified(c, channelId, rssRequestParams) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		data := channel.BuildChannelRssFeed(channelId, rssRequestParams, handler(c.Request()))
+		writeFeedValidators(c, channelId, rssRequestParams)
+		return writeFeed(c, data, rssRequestParams.Format)
 	})
 
 	e.GET("/rss/:youtubePlaylistId", func(c echo.Context) error {
 		if err := checkAuthentication(c); err != nil {
 			return err
 		}
-		validateQueryParams(c)
+		rssRequestParams, err := feedRequestParams(c)
+		if err != nil {
+			return err
+		}
 		playlistId := strings.Split(c.Param("youtubePlaylistId"), "&")[0]
-		data := playlist.BuildPlaylistRssFeed(playlistId, handler(c.Request()))
-		c.Response().Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
-		c.Response().Header().Set("Content-Length", strconv.Itoa(len(data)))
-		c.Response().Header().Del("Transfer-Encoding")
-		return c.Blob(http.StatusOK, "application/rss+xml; charset=utf-8", data)
+		if feedNotModified(c, playlistId, rssRequestParams) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		data := playlist.BuildPlaylistRssFeed(playlistId, rssRequestParams, handler(c.Request()))
+		writeFeedValidators(c, playlistId, rssRequestParams)
+		return writeFeed(c, data, rssRequestParams.Format)
 	})
 
 	e.GET("/media/:youtubeVideoId", func(c echo.Context) error {
+		return serveEpisode(c, enum.AUDIO)
+	})
+
+	e.GET("/video/:youtubeVideoId", func(c echo.Context) error {
+		return serveEpisode(c, enum.VIDEO)
+	})
+
+	e.GET("/chapters/:youtubeVideoId", func(c echo.Context) error {
 		if err := checkAuthentication(c); err != nil {
 			return err
 		}
 
 		youtubeVideoId := c.Param("youtubeVideoId")
-		if strings.Contains(youtubeVideoId, "/") || strings.Contains(youtubeVideoId, "\\") || strings.Contains(youtubeVideoId, "..") {
-			return echo.NewHTTPError(http.StatusBadRequest, "Invalid file name")
+		if !common.IsValidParam(youtubeVideoId) || !common.IsValidID(youtubeVideoId) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid video id")
 		}
-		if !common.IsValidParam(youtubeVideoId) {
-			c.Error(echo.NewHTTPError(http.StatusBadRequest, "Invalid channel id"))
+
+		variant, err := episodeVariant(c, youtubeVideoId)
+		if err != nil {
+			return err
 		}
 
-		audioDirAbs, err := filepath.Abs(config.AppConfig.Setup.AudioDir)
+		episodeChapters, err := chapters.BuildChapters(youtubeVideoId, variant)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Server config error")
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "Episode not found")
+			}
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error building chapters")
+		}
+		if episodeChapters == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "No chapters found")
 		}
 
-		needRedownload, totalTimeSkipped := sponsorblock.DeterminePodcastDownload(youtubeVideoId)
-		database.UpdateEpisodePlaybackHistory(youtubeVideoId, totalTimeSkipped)
+		data, err := json.Marshal(episodeChapters)
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, "application/json+chapters", data)
+	})
 
-		filePath := database.FindFileWithId(audioDirAbs, youtubeVideoId)
-		file, err := os.Open(filePath)
+	e.GET("/auto-download/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcast := database.GetPodcast(c.Param("podcastId"))
+		if podcast == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+		return c.JSON(http.StatusOK, podcast.AutoDownload)
+	})
 
-		if file == nil || err != nil || needRedownload {
-			done := downloader.GetYoutubeVideo(youtubeVideoId)
-			<-done
-			filePath = database.FindFileWithId(audioDirAbs, youtubeVideoId)
-			file, err = os.Open(filePath)
-			if err != nil || file == nil {
-				return err
-			}
-			defer file.Close()
+	e.PUT("/auto-download/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcast := database.GetPodcast(c.Param("podcastId"))
+		if podcast == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+
+		var policy models.AutoDownloadPolicy
+		if err := c.Bind(&policy); err != nil || policy.Latest < 0 || policy.NewerThanDays < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid auto-download policy")
+		}
+		if err := database.UpdatePodcastAutoDownload(podcast.Id, policy); err != nil {
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error saving auto-download policy")
+		}
+		// Apply the new policy to the episodes that are already known.
+		go autodownload.Run(podcast.Id, podcast.PodcastType())
+		return c.JSON(http.StatusOK, policy)
+	})
+
+	e.GET("/transcript/:youtubeVideoId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+
+		youtubeVideoId := c.Param("youtubeVideoId")
+		if !common.IsValidParam(youtubeVideoId) || !common.IsValidID(youtubeVideoId) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid video id")
+		}
 
-			rangeHeader := c.Request().Header.Get("Range")
-			if rangeHeader != "" {
-				http.ServeFile(c.Response().Writer, c.Request(), filePath)
-				return nil
+		format := transcript.VTT
+		contentType := generator.TranscriptVTT
+		switch strings.ToLower(c.QueryParam("format")) {
+		case "", "vtt":
+		case "srt":
+			format = transcript.SRT
+			contentType = generator.TranscriptSRT
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid format, expected vtt or srt")
+		}
+
+		variant, err := episodeVariant(c, youtubeVideoId)
+		if err != nil {
+			return err
+		}
+
+		data, err := transcript.BuildTranscript(youtubeVideoId, format, variant)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "Episode not found")
 			}
-			return c.Stream(http.StatusOK, "audio/mp4", file)
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error building transcript")
+		}
+		if data == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "No transcript found")
 		}
+		return c.Blob(http.StatusOK, contentType+"; charset=utf-8", data)
+	})
 
-		defer file.Close()
-		rangeHeader := c.Request().Header.Get("Range")
-		if rangeHeader != "" {
-			http.ServeFile(c.Response().Writer, c.Request(), filePath)
-			return nil
+	e.GET("/filter/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcast := database.GetPodcast(c.Param("podcastId"))
+		if podcast == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+		return c.JSON(http.StatusOK, podcast.Filter)
+	})
+
+	e.PUT("/filter/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcastId := c.Param("podcastId")
+		if database.GetPodcast(podcastId) == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+
+		var filter models.EpisodeFilter
+		if err := c.Bind(&filter); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid filter")
+		}
+		if _, err := models.CompileEpisodeFilter(filter); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := database.UpdatePodcastFilter(podcastId, filter); err != nil {
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error saving filter")
+		}
+		return c.JSON(http.StatusOK, filter)
+	})
+
+	e.GET("/audio-profile/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcast := database.GetPodcast(c.Param("podcastId"))
+		if podcast == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+		return c.JSON(http.StatusOK, audioProfileSetting{Profile: podcast.AudioProfile})
+	})
+
+	e.PUT("/audio-profile/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcastId := c.Param("podcastId")
+		if database.GetPodcast(podcastId) == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+
+		var setting audioProfileSetting
+		if err := c.Bind(&setting); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid audio profile")
+		}
+		if _, ok := config.AppConfig.AudioProfiles[setting.Profile]; setting.Profile != "" && !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, "Unknown audio profile")
+		}
+		if err := database.UpdatePodcastAudioProfile(podcastId, setting.Profile); err != nil {
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error saving audio profile")
+		}
+		return c.JSON(http.StatusOK, setting)
+	})
+
+	e.GET("/audio-processing/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcast := database.GetPodcast(c.Param("podcastId"))
+		if podcast == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+		return c.JSON(http.StatusOK, podcast.Processing)
+	})
+
+	e.PUT("/audio-processing/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcastId := c.Param("podcastId")
+		if database.GetPodcast(podcastId) == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+
+		var processing models.AudioProcessing
+		if err := c.Bind(&processing); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid audio processing")
+		}
+		// atempo handles 0.5x to 2x in a single pass.
+		if processing.Tempo != 0 && (processing.Tempo < 0.5 || processing.Tempo > 2) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid tempo, expected 0.5 to 2")
+		}
+		if err := database.UpdatePodcastProcessing(podcastId, processing); err != nil {
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error saving audio processing")
+		}
+		return c.JSON(http.StatusOK, processing)
+	})
+
+	e.GET("/sponsorblock/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcast := database.GetPodcast(c.Param("podcastId"))
+		if podcast == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+		setting := sponsorBlockSetting{Categories: []string{}, Mode: sponsorblock.PodcastMode(podcast)}
+		if podcast.SponsorBlockCategories != "" {
+			setting.Categories = strings.Split(podcast.SponsorBlockCategories, ",")
+		}
+		return c.JSON(http.StatusOK, setting)
+	})
+
+	e.PUT("/sponsorblock/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcastId := c.Param("podcastId")
+		if database.GetPodcast(podcastId) == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+
+		var setting sponsorBlockSetting
+		if err := c.Bind(&setting); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid SponsorBlock categories")
+		}
+		categories, err := sponsorblock.ParseCategories(strings.Join(setting.Categories, ","))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		mode := ""
+		switch setting.Mode {
+		case "", sponsorblock.CutMode:
+			setting.Mode = sponsorblock.CutMode
+		case sponsorblock.MarkMode:
+			mode = sponsorblock.MarkMode
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid mode, expected cut or mark")
+		}
+		// Cached episodes are cut again once their skipped time no longer
+		// matches, see sponsorblock.DeterminePodcastDownload.
+		if err := database.UpdatePodcastSponsorBlock(podcastId, sponsorblock.CategoryKey(categories), mode); err != nil {
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error saving SponsorBlock categories")
+		}
+		if categories == nil {
+			categories = []string{}
+		}
+		return c.JSON(http.StatusOK, sponsorBlockSetting{Categories: categories, Mode: setting.Mode})
+	})
+
+	e.GET("/retention/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcast := database.GetPodcast(c.Param("podcastId"))
+		if podcast == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+		return c.JSON(http.StatusOK, podcast.Retention)
+	})
+
+	e.PUT("/retention/:podcastId", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+		podcastId := c.Param("podcastId")
+		if database.GetPodcast(podcastId) == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Podcast not found")
+		}
+
+		var policy models.RetentionPolicy
+		if err := c.Bind(&policy); err != nil || policy.KeepLatest < 0 || policy.ExpireDays < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid retention policy")
+		}
+		if err := database.UpdatePodcastRetention(podcastId, policy); err != nil {
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error saving retention policy")
+		}
+		return c.JSON(http.StatusOK, policy)
+	})
+
+	e.PUT("/pin/:youtubeVideoId", func(c echo.Context) error {
+		return pinEpisode(c, true)
+	})
+
+	e.DELETE("/pin/:youtubeVideoId", func(c echo.Context) error {
+		return pinEpisode(c, false)
+	})
+
+	e.GET("/downloads", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
 		}
-		return c.Stream(http.StatusOK, "audio/mp4", file)
+		jobs, err := downloader.GetDownloadJobs()
+		if err != nil {
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error loading downloads")
+		}
+		return c.JSON(http.StatusOK, jobs)
 	})
 
+	e.GET("/opml", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+
+		data, err := opml.Export(handler(c.Request()))
+		if err != nil {
+			log.Error(err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Error exporting feeds")
+		}
+		c.Response().Header().Set("Content-Disposition", `attachment; filename="clean-cast.opml"`)
+		return c.Blob(http.StatusOK, "text/x-opml; charset=utf-8", data)
+	})
+
+	e.POST("/opml", func(c echo.Context) error {
+		if err := checkAuthentication(c); err != nil {
+			return err
+		}
+
+		data, err := readOpmlUpload(c)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Unable to read OPML file")
+		}
+		result, err := opml.Import(data)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, result)
+	})
+}
+
+// serverAddress returns the address the server listens on, from the HOST and
+// PORT environment variables.
+func serverAddress() string {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8082"
 	}
 	host := os.Getenv("HOST")
+	return host + ":" + port
+}
+
+// pinEpisode pins or unpins an episode, keeping it in the cache no matter the
+// retention policy or cache budget.
+func pinEpisode(c echo.Context, pinned bool) error {
+	if err := checkAuthentication(c); err != nil {
+		return err
+	}
+	youtubeVideoId := c.Param("youtubeVideoId")
+	if !common.IsValidParam(youtubeVideoId) || !common.IsValidID(youtubeVideoId) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid video id")
+	}
+
+	found, err := database.SetEpisodePinned(youtubeVideoId, pinned)
+	if err != nil {
+		log.Error(err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error saving pin")
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "Episode not found")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// audioProfileSetting is the body of the /audio-profile endpoints.
+type audioProfileSetting struct {
+	Profile string `json:"profile"`
+}
+
+// sponsorBlockSetting is the body of the /sponsorblock endpoints. No
+// categories stands for the configured ones. Mode is "cut", the default, or
+// "mark" to keep the segments and mark them with chapters.
+type sponsorBlockSetting struct {
+	Categories []string `json:"categories"`
+	Mode       string   `json:"mode"`
+}
+
+// feedRequestParams validates the query params of a feed request and picks
+// the output format from the format query param or the Accept header.
+func feedRequestParams(c echo.Context) (*models.RssRequestParams, error) {
+	params := validateQueryParams(c)
+	if params == nil {
+		params = &models.RssRequestParams{}
+	}
+
+	format, err := parseFeedFormat(c)
+	if err != nil {
+		return nil, err
+	}
+	params.Format = format
+
+	if pageVar := c.QueryParam("page"); pageVar != "" {
+		page, err := strconv.Atoi(pageVar)
+		if err != nil || page < 0 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid page")
+		}
+		params.Page = &page
+	}
+
+	switch strings.ToLower(c.QueryParam("media")) {
+	case "", "audio":
+		params.Media = enum.AUDIO
+	case "video":
+		params.Media = enum.VIDEO
+	default:
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid media, expected audio or video")
+	}
+
+	profile, err := audioProfileParam(c)
+	if err != nil {
+		return nil, err
+	}
+	params.Profile = profile
+
+	categories, err := sponsorBlockParam(c)
+	if err != nil {
+		return nil, err
+	}
+	params.SponsorBlock = categories
+
+	mode, err := sponsorBlockModeParam(c)
+	if err != nil {
+		return nil, err
+	}
+	params.SponsorBlockMode = mode
+
+	params.Filter = models.EpisodeFilter{
+		IncludeTitle:       c.QueryParam("include_title"),
+		ExcludeTitle:       c.QueryParam("exclude_title"),
+		IncludeDescription: c.QueryParam("include_description"),
+		ExcludeDescription: c.QueryParam("exclude_description"),
+	}
+	if _, err := models.CompileEpisodeFilter(params.Filter); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return params, nil
+}
+
+// audioProfileParam returns the audio profile requested with ?profile=,
+// which has to be one of the configured profiles.
+func audioProfileParam(c echo.Context) (string, error) {
+	profile := c.QueryParam("profile")
+	if profile == "" {
+		return "", nil
+	}
+	if _, ok := config.AppConfig.AudioProfiles[profile]; !ok {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "Unknown audio profile")
+	}
+	return profile, nil
+}
+
+// sponsorBlockParam returns the key of the SponsorBlock categories requested
+// with ?sb=, "" when none are.
+func sponsorBlockParam(c echo.Context) (string, error) {
+	categories, err := sponsorblock.ParseCategories(c.QueryParam("sb"))
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return sponsorblock.CategoryKey(categories), nil
+}
+
+// sponsorBlockModeParam returns whether ?sb_mode= asks for the segments to
+// be cut or marked with chapters, "" for the podcast's mode.
+func sponsorBlockModeParam(c echo.Context) (string, error) {
+	switch mode := strings.ToLower(c.QueryParam("sb_mode")); mode {
+	case "", sponsorblock.CutMode, sponsorblock.MarkMode:
+		return mode, nil
+	default:
+		return "", echo.NewHTTPError(http.StatusBadRequest, "Invalid sb_mode, expected cut or mark")
+	}
+}
+
+// episodeVariant returns the key of the variant of an episode requested with
+// ?sb= and ?sb_mode=, "" for the podcast's own cut. Episodes of podcasts that
+// mark their segments are marked unless the cut is asked for.
+func episodeVariant(c echo.Context, youtubeVideoId string) (string, error) {
+	categories, err := sponsorBlockParam(c)
+	if err != nil {
+		return "", err
+	}
+	mode, err := sponsorBlockModeParam(c)
+	if err != nil {
+		return "", err
+	}
+	return sponsorblock.EpisodeVariantKey(youtubeVideoId, categories, mode), nil
+}
+
+func parseFeedFormat(c echo.Context) (enum.FeedFormat, error) {
+	switch strings.ToLower(c.QueryParam("format")) {
+	case "":
+	case "rss", "xml":
+		return enum.RSS, nil
+	case "atom":
+		return enum.ATOM, nil
+	case "json", "jsonfeed":
+		return enum.JSON_FEED, nil
+	default:
+		return "", echo.NewHTTPError(http.StatusBadRequest, "Invalid format, expected rss, atom or json")
+	}
+
+	return acceptedFeedFormat(c.Request().Header.Get("Accept")), nil
+}
+
+// feedMediaTypes maps the media types of an Accept header to the feed format
+// they ask for.
+var feedMediaTypes = map[string]enum.FeedFormat{
+	"application/rss+xml":         enum.RSS,
+	generator.AtomContentType:     enum.ATOM,
+	generator.JSONFeedContentType: enum.JSON_FEED,
+	"application/json":            enum.JSON_FEED,
+}
+
+// acceptedFeedFormat returns the feed format of the media range with the
+// highest q-value in an Accept header, the first listed one on a tie. It
+// falls back to RSS when no feed format is accepted.
+func acceptedFeedFormat(accept string) enum.FeedFormat {
+	format, best := enum.RSS, 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		candidate, ok := feedMediaTypes[strings.ToLower(strings.TrimSpace(mediaType))]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > best {
+			format, best = candidate, q
+		}
+	}
+	return format
+}
+
+// serveEpisode serves the cached audio or video of an episode, downloading it
+// first when it is missing or the sponsor segments have changed. Episodes
+// requested with other SponsorBlock categories than their podcast's, or with
+// the segments marked instead of cut, are served as downloaded, without
+// audio processing or profiles.
+func serveEpisode(c echo.Context, media enum.MediaType) error {
+	if err := checkAuthentication(c); err != nil {
+		return err
+	}
+
+	youtubeVideoId := c.Param("youtubeVideoId")
+	if strings.Contains(youtubeVideoId, "/") || strings.Contains(youtubeVideoId, "\\") || strings.Contains(youtubeVideoId, "..") {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid file name")
+	}
+	if !common.IsValidParam(youtubeVideoId) {
+		c.Error(echo.NewHTTPError(http.StatusBadRequest, "Invalid channel id"))
+	}
+
+	profile := ""
+	if media == enum.AUDIO {
+		var err error
+		if profile, err = audioProfileParam(c); err != nil {
+			return err
+		}
+	}
+
+	variant, err := episodeVariant(c, youtubeVideoId)
+	if err != nil {
+		return err
+	}
+
+	mediaDir := config.MediaDir(media)
+	switch {
+	case variant != "":
+		profile = ""
+		mediaDir = config.VariantDir(media, variant)
+	case profile != "":
+		mediaDir = config.ProfileDir(profile)
+	}
+	mediaDirAbs, err := filepath.Abs(mediaDir)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Server config error")
+	}
+
+	segmentsChanged, totalTimeSkipped := sponsorblock.DeterminePodcastDownload(youtubeVideoId)
+	database.UpdateEpisodePlaybackHistory(youtubeVideoId, totalTimeSkipped)
+	if segmentsChanged {
+		// The outdated cut is served until the new one replaces it.
+		downloader.RecutCached(youtubeVideoId)
+	}
+	needRedownload := false
+	if media == enum.AUDIO && variant == "" && downloader.AudioProcessingOutdated(youtubeVideoId) {
+		log.Debug("[PROCESSING] Audio processing of the podcast changed, downloading again...")
+		database.RemoveEpisodeFiles(youtubeVideoId)
+		needRedownload = true
+	}
+
+	if media == enum.AUDIO && profile == "" && variant == "" && config.AppConfig.Ytdlp.StreamDownloads {
+		if stream := downloader.StreamYoutubeAudio(youtubeVideoId); stream != nil {
+			return streamEpisode(c, stream)
+		}
+	}
+
+	filePath := database.FindFileWithId(mediaDirAbs, youtubeVideoId)
+	if filePath == "" || needRedownload {
+		var done <-chan struct{}
+		switch {
+		case variant != "":
+			done = downloader.GetYoutubeMediaVariant(youtubeVideoId, media, variant)
+		case profile != "":
+			done = downloader.GetYoutubeAudioProfile(youtubeVideoId, profile)
+		default:
+			done = downloader.GetYoutubeMedia(youtubeVideoId, media)
+		}
+		<-done
+		filePath = database.FindFileWithId(mediaDirAbs, youtubeVideoId)
+		if filePath == "" {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Episode could not be downloaded")
+		}
+	}
+	return serveMediaFile(c, filePath)
+}
 
-	log.Debug("Starting server on " + host + ": " + port)
-	e.Logger.Fatal(e.Start(host + ":" + port))
+// streamEpisode sends an episode to the client while it is still being
+// downloaded. The final length isn't known yet, so the response is chunked
+// and range requests are answered with the whole stream.
+func streamEpisode(c echo.Context, stream *downloader.AudioStream) error {
+	c.Response().Header().Set(echo.HeaderContentType, stream.ContentType())
+	c.Response().WriteHeader(http.StatusOK)
+	if err := stream.Follow(c.Request().Context(), flushWriter{c.Response()}); err != nil {
+		log.Warnf("Streaming %s ended early: %v", c.Param("youtubeVideoId"), err)
+	}
+	return nil
+}
+
+// flushWriter flushes every write so streamed audio reaches the client as
+// soon as it is produced.
+type flushWriter struct {
+	response *echo.Response
+}
 
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.response.Write(p)
+	w.response.Flush()
+	return n, err
+}
+
+// mediaContentType returns the MIME type of a cached episode file based on
+// the container yt-dlp produced.
+func mediaContentType(filePath string) string {
+	return common.MediaTypeFromExtension(filepath.Ext(filePath))
+}
+
+// serveMediaFile sends a cached episode from the storage backend, or
+// redirects to it when the backend hands out presigned URLs.
+func serveMediaFile(c echo.Context, filePath string) error {
+	url, err := storage.URL(filePath)
+	if err != nil {
+		log.Warnf("[STORAGE] Unable to presign %s, serving it instead: %v", filePath, err)
+	}
+	if url != "" {
+		return c.Redirect(http.StatusFound, url)
+	}
+
+	file, info, err := storage.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// ServeContent answers both plain and range requests and advertises
+	// Accept-Ranges, so players can seek in the cached file.
+	c.Response().Header().Set("Content-Type", mediaContentType(filePath))
+	http.ServeContent(c.Response(), c.Request(), filepath.Base(filePath), info.ModTime, file)
+	return nil
+}
+
+// maxOpmlSize limits the size of an uploaded OPML file.
+const maxOpmlSize = 5 << 20
+
+// readOpmlUpload reads the OPML document either from a multipart "file" field
+// or from the raw request body.
+func readOpmlUpload(c echo.Context) ([]byte, error) {
+	if fileHeader, err := c.FormFile("file"); err == nil {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return io.ReadAll(io.LimitReader(file, maxOpmlSize))
+	}
+	return io.ReadAll(io.LimitReader(c.Request().Body, maxOpmlSize))
+}
+
+func writeFeed(c echo.Context, data []byte, format enum.FeedFormat) error {
+	contentType := rss.FeedContentType(format)
+	c.Response().Header().Set("Content-Type", contentType)
+	c.Response().Header().Set("Content-Length", strconv.Itoa(len(data)))
+	c.Response().Header().Del("Transfer-Encoding")
+	c.Response().Header().Set("Vary", "Accept")
+	return c.Blob(http.StatusOK, contentType, data)
 }
 
 func validateQueryParams(c echo.Context) *models.RssRequestParams {
@@ -139,7 +822,7 @@ func validateQueryParams(c echo.Context) *models.RssRequestParams {
 	return &models.RssRequestParams{Limit: nil, Date: nil}
 }
 
-func setupCron() {
+func setupCron() *cron.Cron {
 	cronSchedule := "0 0 * * 0"
 	if config.AppConfig.Setup.Cron != "" {
 		cronSchedule = config.AppConfig.Setup.Cron
@@ -147,8 +830,16 @@ func setupCron() {
 	c := cron.New()
 	c.AddFunc(cronSchedule, func() {
 		database.DeletePodcastCronJob()
+		database.DeleteExpiredSourceAudio()
+		sponsorblock.PruneSegmentCache()
 	})
+	// Runs every interval, each episode is only looked up once its own
+	// delay, growing with its age, has passed.
+	if interval := config.SponsorBlockRecheckInterval(); interval > 0 {
+		c.AddFunc("@every "+interval.String(), recheck.Run)
+	}
 	c.Start()
+	return c
 }
 
 func setupHandlers(e *echo.Echo) {
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"testing"
+)
+
+func TestAcceptedFeedFormat(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   enum.FeedFormat
+	}{
+		{"", enum.RSS},
+		{"*/*", enum.RSS},
+		{"application/atom+xml", enum.ATOM},
+		{"application/feed+json, application/rss+xml", enum.JSON_FEED},
+		{"application/rss+xml;q=0.5, application/atom+xml;q=0.9", enum.ATOM},
+		{"application/json; q=0, application/rss+xml", enum.RSS},
+		{"text/html, application/atom+xml;q=0.1, */*;q=0.8", enum.ATOM},
+	}
+	for _, tc := range cases {
+		if got := acceptedFeedFormat(tc.accept); got != tc.want {
+			t.Errorf("acceptedFeedFormat(%q) = %s, want %s", tc.accept, got, tc.want)
+		}
+	}
+}
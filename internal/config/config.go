@@ -2,9 +2,13 @@ package config
 
 import (
 	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
 	"os"
 	"path"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
@@ -15,10 +19,20 @@ type Config struct {
 	Setup struct {
 		GoogleApiKey           string `mapstructure:"google-api-key" validate:"required"`
 		AudioDir               string
+		VideoDir               string
+		TranscriptDir          string
+		SourceDir              string
 		Cron                   string `mapstructure:"cron"`
 		ConfigDir              string `mapstructure:"config-dir" validate:"required"`
 		DbFile                 string
 		PodcastRefreshInterval string `mapstructure:"podcast-refresh-interval"`
+		FeedPageSize           int    `mapstructure:"feed-page-size" validate:"gte=0"`
+		FeedLocked             bool   `mapstructure:"feed-locked"`
+		FeedOwnerEmail         string `mapstructure:"feed-owner-email" validate:"omitempty,email"`
+		CacheExpiryDays        int    `mapstructure:"cache-expiry-days" validate:"gte=0"`
+		MaxCacheSize           string `mapstructure:"max-cache-size"`
+		MaxCacheBytes          int64
+		ShutdownTimeout        string `mapstructure:"shutdown-timeout"`
 	} `mapstructure:"setup"`
 
 	Ntfy struct {
@@ -41,14 +55,70 @@ type Config struct {
 		} `mapstructure:"basic-auth"`
 	} `mapstructure:"authentication"`
 
+	Loudness struct {
+		Enabled    bool    `mapstructure:"enabled"`
+		TargetLufs float64 `mapstructure:"target-lufs" validate:"gte=-70,lte=-5"`
+		TruePeak   float64 `mapstructure:"true-peak" validate:"gte=-9,lte=0"`
+	} `mapstructure:"loudness"`
+
+	SponsorBlock struct {
+		Server      string `mapstructure:"server" validate:"omitempty,url"`
+		CacheTtl    string `mapstructure:"cache-ttl"`
+		Timeout     string `mapstructure:"timeout"`
+		MinVotes    int    `mapstructure:"min-votes"`
+		LockedOnly  bool   `mapstructure:"locked-only"`
+		ActionTypes string `mapstructure:"action-types"`
+		// RecheckInterval is how often recent and cached episodes are looked
+		// up again, RecheckDays how long after publishing episodes that
+		// aren't cached are included.
+		RecheckInterval string `mapstructure:"recheck-interval"`
+		RecheckDays     int    `mapstructure:"recheck-days" validate:"gte=0"`
+	} `mapstructure:"sponsorblock"`
+
+	Storage struct {
+		Backend string `mapstructure:"backend" validate:"oneof=filesystem s3"`
+		S3      struct {
+			Endpoint      string `mapstructure:"endpoint"`
+			Bucket        string `mapstructure:"bucket"`
+			Region        string `mapstructure:"region"`
+			AccessKey     string `mapstructure:"access-key"`
+			SecretKey     string `mapstructure:"secret-key"`
+			Prefix        string `mapstructure:"prefix"`
+			UseSSL        bool   `mapstructure:"use-ssl"`
+			PathStyle     bool   `mapstructure:"path-style"`
+			Redirect      bool   `mapstructure:"redirect"`
+			PresignExpiry string `mapstructure:"presign-expiry"`
+		} `mapstructure:"s3"`
+	} `mapstructure:"storage"`
+
+	AudioProfiles map[string]AudioProfile `mapstructure:"audio-profiles" validate:"dive,keys,required,excludesall=/\\.,endkeys"`
+
 	Ytdlp struct {
 		CookiesFile            string `mapstructure:"cookies-file"`
 		SponsorBlockCategories string `mapstructure:"sponsorblock-categories"`
 		EpisodeDurationMinimum string `mapstructure:"episode-duration-minimum"`
 		YtdlpExtractorArgs     string `mapstructure:"ytdlp-extractor-args"`
+		VideoMaxHeight         int    `mapstructure:"video-max-height" validate:"gte=0"`
+		SubtitleLanguage       string `mapstructure:"subtitle-language"`
+		DownloadConcurrency    int    `mapstructure:"download-concurrency" validate:"gte=1"`
+		DownloadRetries        int    `mapstructure:"download-retries" validate:"gte=0"`
+		StreamDownloads        bool   `mapstructure:"stream-downloads"`
+		KeepSourceAudio        bool   `mapstructure:"keep-source-audio"`
+		SourceRetentionDays    int    `mapstructure:"source-retention-days" validate:"gte=0"`
+		DownloadTimeout        string `mapstructure:"download-timeout"`
+		ShutdownDownloads      string `mapstructure:"shutdown-downloads" validate:"oneof=cancel wait"`
 	} `mapstructure:"ytdlp"`
 }
 
+// AudioProfile describes an ffmpeg transcode of the downloaded audio for
+// players that can't play the original format.
+type AudioProfile struct {
+	Codec      string `mapstructure:"codec" validate:"required,oneof=mp3 aac opus vorbis flac"`
+	Bitrate    string `mapstructure:"bitrate"`
+	Channels   int    `mapstructure:"channels" validate:"gte=0"`
+	SampleRate int    `mapstructure:"sample-rate" validate:"gte=0"`
+}
+
 var validate = validator.New()
 
 var AppConfig *Config
@@ -74,7 +144,30 @@ func Load() (*Config, error) {
 	v.SetDefault("ytdlp.episode-duration-minimum", "3m")
 	v.SetDefault("setup.config-dir", configDir)
 	v.SetDefault("setup.audio-dir", "audio")
+	v.SetDefault("setup.cache-expiry-days", 7)
+	v.SetDefault("setup.feed-locked", true)
+	v.SetDefault("setup.shutdown-timeout", "30s")
 	v.SetDefault("ytdlp.sponsorblock-categories", "sponsor")
+	v.SetDefault("ytdlp.video-max-height", 720)
+	v.SetDefault("ytdlp.subtitle-language", "en")
+	v.SetDefault("ytdlp.download-concurrency", 2)
+	v.SetDefault("ytdlp.download-retries", 3)
+	v.SetDefault("ytdlp.source-retention-days", 30)
+	v.SetDefault("ytdlp.download-timeout", "2h")
+	v.SetDefault("ytdlp.shutdown-downloads", "cancel")
+	v.SetDefault("sponsorblock.server", "https://sponsor.ajay.app")
+	v.SetDefault("sponsorblock.cache-ttl", "1h")
+	v.SetDefault("sponsorblock.timeout", "10s")
+	v.SetDefault("sponsorblock.min-votes", 0)
+	v.SetDefault("sponsorblock.locked-only", false)
+	v.SetDefault("sponsorblock.action-types", "skip")
+	v.SetDefault("sponsorblock.recheck-interval", "1h")
+	v.SetDefault("sponsorblock.recheck-days", 7)
+	v.SetDefault("storage.backend", "filesystem")
+	v.SetDefault("storage.s3.use-ssl", true)
+	v.SetDefault("storage.s3.presign-expiry", "1h")
+	v.SetDefault("loudness.target-lufs", -16)
+	v.SetDefault("loudness.true-peak", -1.5)
 	v.SetDefault("setup.google-api-key", os.Getenv("GOOGLE_API_KEY"))
 	if os.Getenv("PODCAST_REFRESH_INTERVAL") != "" {
 		v.SetDefault("setup.podcast-refresh-interval", os.Getenv("PODCAST_REFRESH_INTERVAL"))
@@ -91,6 +184,12 @@ func Load() (*Config, error) {
 	v.BindEnv("setup.audio-dir", "AUDIO_DIR")
 	v.BindEnv("setup.google-api-key", "GOOGLE_API_KEY")
 	v.BindEnv("setup.podcast-refresh-interval", "PODCAST_REFRESH_INTERVAL")
+	v.BindEnv("setup.feed-page-size", "FEED_PAGE_SIZE")
+	v.BindEnv("setup.feed-locked", "FEED_LOCKED")
+	v.BindEnv("setup.feed-owner-email", "FEED_OWNER_EMAIL")
+	v.BindEnv("setup.cache-expiry-days", "CACHE_EXPIRY_DAYS")
+	v.BindEnv("setup.max-cache-size", "MAX_CACHE_SIZE")
+	v.BindEnv("setup.shutdown-timeout", "SHUTDOWN_TIMEOUT")
 	v.BindEnv("ytdlp.cookies-file", "COOKIES_FILE")
 	v.BindEnv("ntfy.server", "NTFY_SERVER")
 	v.BindEnv("ntfy.topic", "NTFY_TOPIC")
@@ -99,6 +198,37 @@ func Load() (*Config, error) {
 	v.BindEnv("ytdlp.episode-duration-minimum", "MIN_DURATION")
 	v.BindEnv("ytdlp.sponsorblock-categories", "SPONSORBLOCK_CATEGORIES")
 	v.BindEnv("ytdlp.ytdlp-extractor-args", "YTDLP_EXTRACTOR_ARGS")
+	v.BindEnv("ytdlp.video-max-height", "VIDEO_MAX_HEIGHT")
+	v.BindEnv("ytdlp.subtitle-language", "SUBTITLE_LANGUAGE")
+	v.BindEnv("ytdlp.download-concurrency", "DOWNLOAD_CONCURRENCY")
+	v.BindEnv("ytdlp.download-retries", "DOWNLOAD_RETRIES")
+	v.BindEnv("ytdlp.stream-downloads", "STREAM_DOWNLOADS")
+	v.BindEnv("ytdlp.keep-source-audio", "KEEP_SOURCE_AUDIO")
+	v.BindEnv("ytdlp.source-retention-days", "SOURCE_RETENTION_DAYS")
+	v.BindEnv("ytdlp.download-timeout", "DOWNLOAD_TIMEOUT")
+	v.BindEnv("ytdlp.shutdown-downloads", "SHUTDOWN_DOWNLOADS")
+	v.BindEnv("sponsorblock.server", "SPONSORBLOCK_SERVER")
+	v.BindEnv("sponsorblock.cache-ttl", "SPONSORBLOCK_CACHE_TTL")
+	v.BindEnv("sponsorblock.timeout", "SPONSORBLOCK_TIMEOUT")
+	v.BindEnv("sponsorblock.min-votes", "SPONSORBLOCK_MIN_VOTES")
+	v.BindEnv("sponsorblock.locked-only", "SPONSORBLOCK_LOCKED_ONLY")
+	v.BindEnv("sponsorblock.action-types", "SPONSORBLOCK_ACTION_TYPES")
+	v.BindEnv("sponsorblock.recheck-interval", "SPONSORBLOCK_RECHECK_INTERVAL")
+	v.BindEnv("sponsorblock.recheck-days", "SPONSORBLOCK_RECHECK_DAYS")
+	v.BindEnv("storage.backend", "STORAGE_BACKEND")
+	v.BindEnv("storage.s3.endpoint", "S3_ENDPOINT")
+	v.BindEnv("storage.s3.bucket", "S3_BUCKET")
+	v.BindEnv("storage.s3.region", "S3_REGION")
+	v.BindEnv("storage.s3.access-key", "S3_ACCESS_KEY")
+	v.BindEnv("storage.s3.secret-key", "S3_SECRET_KEY")
+	v.BindEnv("storage.s3.prefix", "S3_PREFIX")
+	v.BindEnv("storage.s3.use-ssl", "S3_USE_SSL")
+	v.BindEnv("storage.s3.path-style", "S3_PATH_STYLE")
+	v.BindEnv("storage.s3.redirect", "S3_REDIRECT")
+	v.BindEnv("storage.s3.presign-expiry", "S3_PRESIGN_EXPIRY")
+	v.BindEnv("loudness.enabled", "LOUDNESS_NORMALIZATION")
+	v.BindEnv("loudness.target-lufs", "LOUDNESS_TARGET_LUFS")
+	v.BindEnv("loudness.true-peak", "LOUDNESS_TRUE_PEAK")
 
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
@@ -108,6 +238,40 @@ func Load() (*Config, error) {
 	if err := validate.Struct(&cfg); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
+	if cfg.Setup.MaxCacheSize != "" {
+		maxCacheBytes, err := common.ParseByteSize(cfg.Setup.MaxCacheSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: max-cache-size: %w", err)
+		}
+		cfg.Setup.MaxCacheBytes = maxCacheBytes
+	}
+	timeouts := map[string]string{
+		"download-timeout":              cfg.Ytdlp.DownloadTimeout,
+		"shutdown-timeout":              cfg.Setup.ShutdownTimeout,
+		"sponsorblock.cache-ttl":        cfg.SponsorBlock.CacheTtl,
+		"sponsorblock.timeout":          cfg.SponsorBlock.Timeout,
+		"sponsorblock.recheck-interval": cfg.SponsorBlock.RecheckInterval,
+	}
+	for key, value := range timeouts {
+		if _, err := parseTimeout(value); err != nil {
+			return nil, fmt.Errorf("invalid config: %s: %w", key, err)
+		}
+	}
+
+	for _, actionType := range splitList(cfg.SponsorBlock.ActionTypes) {
+		if !slices.Contains(sponsorBlockActionTypes, actionType) {
+			return nil, fmt.Errorf("invalid config: sponsorblock.action-types: unsupported action type %q, only skip segments can be cut", actionType)
+		}
+	}
+
+	if cfg.Storage.Backend == "s3" {
+		if cfg.Storage.S3.Endpoint == "" || cfg.Storage.S3.Bucket == "" {
+			return nil, fmt.Errorf("invalid config: storage.s3: endpoint and bucket are required")
+		}
+		if expiry, err := time.ParseDuration(cfg.Storage.S3.PresignExpiry); err != nil || expiry <= 0 || expiry > 7*24*time.Hour {
+			return nil, fmt.Errorf("invalid config: storage.s3.presign-expiry: expected a duration of at most 7 days")
+		}
+	}
 
 	AppConfig = &cfg
 	if AppConfig.Ytdlp.CookiesFile != "" {
@@ -115,6 +279,117 @@ func Load() (*Config, error) {
 	}
 	AppConfig.Setup.DbFile = path.Join(AppConfig.Setup.ConfigDir, "sqlite.db")
 	AppConfig.Setup.AudioDir = path.Join(AppConfig.Setup.ConfigDir, "audio")
+	AppConfig.Setup.VideoDir = path.Join(AppConfig.Setup.ConfigDir, "video")
+	AppConfig.Setup.TranscriptDir = path.Join(AppConfig.Setup.ConfigDir, "transcripts")
+	AppConfig.Setup.SourceDir = path.Join(AppConfig.Setup.ConfigDir, "source")
 
 	return &cfg, nil
 }
+
+// ProfileDir returns the directory episodes transcoded with the named audio
+// profile are cached in.
+func ProfileDir(profile string) string {
+	return path.Join(AppConfig.Setup.AudioDir, "profiles", profile)
+}
+
+// VariantDir returns the directory the audio or video of episodes cut with
+// other SponsorBlock categories than their podcast's is cached in.
+func VariantDir(media enum.MediaType, categories string) string {
+	return path.Join(MediaDir(media), "sponsorblock", categories)
+}
+
+// EpisodeDirs returns every directory a downloaded or transcoded episode may
+// be cached in.
+func EpisodeDirs() []string {
+	dirs := []string{AppConfig.Setup.AudioDir, AppConfig.Setup.VideoDir}
+	for profile := range AppConfig.AudioProfiles {
+		dirs = append(dirs, ProfileDir(profile))
+	}
+	return dirs
+}
+
+// DownloadTimeout returns how long a single download or ffmpeg pass may run,
+// 0 for no limit.
+func DownloadTimeout() time.Duration {
+	timeout, _ := parseTimeout(AppConfig.Ytdlp.DownloadTimeout)
+	return timeout
+}
+
+// ShutdownTimeout returns how long a shutdown waits for requests and
+// downloads to finish.
+func ShutdownTimeout() time.Duration {
+	timeout, _ := parseTimeout(AppConfig.Setup.ShutdownTimeout)
+	return timeout
+}
+
+// SponsorBlockServer returns the base URL of the SponsorBlock API.
+func SponsorBlockServer() string {
+	server := strings.TrimRight(AppConfig.SponsorBlock.Server, "/")
+	if server == "" {
+		return "https://sponsor.ajay.app"
+	}
+	return server
+}
+
+// SponsorBlockCacheTtl returns how long fetched SponsorBlock segments are
+// used before they are looked up again.
+func SponsorBlockCacheTtl() time.Duration {
+	ttl, _ := parseTimeout(AppConfig.SponsorBlock.CacheTtl)
+	return ttl
+}
+
+// sponsorBlockActionTypes are the action types whose segments can be cut.
+// Mute segments would have to be silenced rather than cut, and full ones
+// label the whole video, so neither is supported.
+var sponsorBlockActionTypes = []string{"skip"}
+
+// SponsorBlockActionTypes returns the action types of the segments that are
+// honored, only skip when none are configured.
+func SponsorBlockActionTypes() []string {
+	actionTypes := splitList(AppConfig.SponsorBlock.ActionTypes)
+	if len(actionTypes) == 0 {
+		return []string{"skip"}
+	}
+	return actionTypes
+}
+
+// splitList splits a comma separated config value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SponsorBlockTimeout returns how long a SponsorBlock lookup may take, 0 for
+// no limit.
+func SponsorBlockTimeout() time.Duration {
+	timeout, _ := parseTimeout(AppConfig.SponsorBlock.Timeout)
+	return timeout
+}
+
+// SponsorBlockRecheckInterval returns how often the segments of recent and
+// cached episodes are looked up again, 0 when they aren't.
+func SponsorBlockRecheckInterval() time.Duration {
+	interval, _ := parseTimeout(AppConfig.SponsorBlock.RecheckInterval)
+	return interval
+}
+
+func parseTimeout(value string) (time.Duration, error) {
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// MediaDir returns the directory downloaded episodes of the given media type
+// are cached in.
+func MediaDir(media enum.MediaType) string {
+	if media == enum.VIDEO {
+		return AppConfig.Setup.VideoDir
+	}
+	return AppConfig.Setup.AudioDir
+}
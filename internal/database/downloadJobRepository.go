@@ -0,0 +1,185 @@
+package database
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// EnqueueDownloadJob queues a download of the video, or a transcode when a
+// profile is given, or a download cut with other categories when categories
+// are given, or returns the job that is already queued or running for it.
+// Finished and failed jobs are queued again from scratch.
+func EnqueueDownloadJob(youtubeVideoId string, media enum.MediaType, profile string, categories string) (*models.DownloadJob, error) {
+	return enqueueDownloadJob(youtubeVideoId, media, profile, categories, false)
+}
+
+// EnqueueReplaceJob queues a download like EnqueueDownloadJob that replaces
+// the cached file once it is done. A job that is already queued or running
+// is returned as is.
+func EnqueueReplaceJob(youtubeVideoId string, media enum.MediaType, profile string, categories string) (*models.DownloadJob, error) {
+	return enqueueDownloadJob(youtubeVideoId, media, profile, categories, true)
+}
+
+func enqueueDownloadJob(youtubeVideoId string, media enum.MediaType, profile string, categories string, replace bool) (*models.DownloadJob, error) {
+	var job models.DownloadJob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("youtube_video_id = ? AND media = ? AND profile = ? AND categories = ?", youtubeVideoId, string(media), profile, categories).First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			job = models.DownloadJob{
+				YoutubeVideoId: youtubeVideoId,
+				Media:          string(media),
+				Profile:        profile,
+				Categories:     categories,
+				Replace:        replace,
+				State:          string(enum.QUEUED),
+				NextAttemptAt:  time.Now(),
+			}
+			return tx.Create(&job).Error
+		}
+		if err != nil {
+			return err
+		}
+		if job.State == string(enum.QUEUED) || job.State == string(enum.RUNNING) {
+			return nil
+		}
+
+		job.State = string(enum.QUEUED)
+		job.Replace = replace
+		job.Attempts = 0
+		job.LastError = ""
+		job.NextAttemptAt = time.Now()
+		return tx.Save(&job).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// StartDownloadJob marks the download of the video as running outside of the
+// queue workers, creating the job when needed. It returns nil when the
+// download is already running.
+func StartDownloadJob(youtubeVideoId string, media enum.MediaType) (*models.DownloadJob, error) {
+	var job models.DownloadJob
+	started := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("youtube_video_id = ? AND media = ? AND profile = '' AND categories = ''", youtubeVideoId, string(media)).First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			job = models.DownloadJob{
+				YoutubeVideoId: youtubeVideoId,
+				Media:          string(media),
+				State:          string(enum.RUNNING),
+				NextAttemptAt:  time.Now(),
+			}
+			started = true
+			return tx.Create(&job).Error
+		}
+		if err != nil || job.State == string(enum.RUNNING) {
+			return err
+		}
+
+		result := tx.Model(&models.DownloadJob{}).
+			Where("id = ? AND state <> ?", job.Id, string(enum.RUNNING)).
+			Updates(map[string]interface{}{"state": string(enum.RUNNING), "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		job.State = string(enum.RUNNING)
+		started = result.RowsAffected == 1
+		return nil
+	})
+	if err != nil || !started {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetDownloadJob returns the job of a video, media type and profile cut with
+// the podcast's categories, or nil when there is none.
+func GetDownloadJob(youtubeVideoId string, media enum.MediaType, profile string) *models.DownloadJob {
+	var job models.DownloadJob
+	err := db.Where("youtube_video_id = ? AND media = ? AND profile = ? AND categories = ''", youtubeVideoId, string(media), profile).First(&job).Error
+	if err != nil {
+		return nil
+	}
+	return &job
+}
+
+// ClaimNextDownloadJob marks the oldest queued job that is due as running and
+// returns it. It returns nil when no job is due.
+func ClaimNextDownloadJob() (*models.DownloadJob, error) {
+	for {
+		var job models.DownloadJob
+		err := db.Where("state = ? AND next_attempt_at <= ?", string(enum.QUEUED), time.Now()).
+			Order("next_attempt_at ASC").
+			First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Another worker may have claimed the job in the meantime.
+		result := db.Model(&models.DownloadJob{}).
+			Where("id = ? AND state = ?", job.Id, string(enum.QUEUED)).
+			Updates(map[string]interface{}{"state": string(enum.RUNNING), "updated_at": time.Now()})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			job.State = string(enum.RUNNING)
+			return &job, nil
+		}
+	}
+}
+
+// UpdateDownloadJob stores the state of a job after an attempt.
+func UpdateDownloadJob(job *models.DownloadJob) error {
+	return db.Save(job).Error
+}
+
+// RequeueRunningDownloadJobs puts jobs interrupted by a restart back in the
+// queue.
+func RequeueRunningDownloadJobs() error {
+	return db.Model(&models.DownloadJob{}).
+		Where("state = ?", string(enum.RUNNING)).
+		Updates(map[string]interface{}{"state": string(enum.QUEUED), "next_attempt_at": time.Now()}).Error
+}
+
+// GetDownloadJobs returns the jobs that are queued, running or failed, most
+// recently updated first.
+func GetDownloadJobs() ([]models.DownloadJob, error) {
+	var jobs []models.DownloadJob
+	err := db.Where("state <> ?", string(enum.DONE)).
+		Order("updated_at DESC").
+		Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetVariantCategories returns every set of categories episodes have been
+// downloaded with apart from their podcast's.
+func GetVariantCategories() []string {
+	var categories []string
+	db.Model(&models.DownloadJob{}).Where("categories <> ''").Distinct().Pluck("categories", &categories)
+	return categories
+}
+
+// GetNextDownloadJobAttempt returns when the next queued job is due.
+func GetNextDownloadJobAttempt() (time.Time, bool) {
+	var job models.DownloadJob
+	err := db.Where("state = ?", string(enum.QUEUED)).
+		Order("next_attempt_at ASC").
+		First(&job).Error
+	if err != nil {
+		return time.Time{}, false
+	}
+	return job.NextAttemptAt, true
+}
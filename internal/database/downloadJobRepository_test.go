@@ -0,0 +1,101 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+)
+
+func TestDownloadJobs_JoinClaimAndRequeue(t *testing.T) {
+	setupTestDB(t)
+
+	first, err := EnqueueDownloadJob("video1", enum.AUDIO, "", "")
+	if err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+	joined, err := EnqueueDownloadJob("video1", enum.AUDIO, "", "")
+	if err != nil {
+		t.Fatalf("second enqueue failed: %v", err)
+	}
+	if joined.Id != first.Id {
+		t.Fatalf("expected the queued job to be joined, got ids %d and %d", first.Id, joined.Id)
+	}
+	if _, err := EnqueueDownloadJob("video1", enum.VIDEO, "", ""); err != nil {
+		t.Fatalf("enqueue video failed: %v", err)
+	}
+
+	claimed, err := ClaimNextDownloadJob()
+	if err != nil || claimed == nil {
+		t.Fatalf("expected a job to be claimed, got %v, %v", claimed, err)
+	}
+	if claimed.Id != first.Id || claimed.State != string(enum.RUNNING) {
+		t.Fatalf("expected the oldest job to be running, got %+v", claimed)
+	}
+
+	// A failed attempt waiting for its retry is not due yet.
+	other, _ := ClaimNextDownloadJob()
+	other.State = string(enum.QUEUED)
+	other.NextAttemptAt = time.Now().Add(time.Hour)
+	if err := UpdateDownloadJob(other); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := ClaimNextDownloadJob(); job != nil {
+		t.Fatalf("expected no job to be due, got %+v", job)
+	}
+
+	// Jobs that were running when the app stopped are picked up again.
+	if err := RequeueRunningDownloadJobs(); err != nil {
+		t.Fatal(err)
+	}
+	requeued, _ := ClaimNextDownloadJob()
+	if requeued == nil || requeued.Id != first.Id {
+		t.Fatalf("expected the interrupted job to be requeued, got %+v", requeued)
+	}
+
+	requeued.State = string(enum.DONE)
+	requeued.Attempts = 1
+	if err := UpdateDownloadJob(requeued); err != nil {
+		t.Fatal(err)
+	}
+	again, err := EnqueueDownloadJob("video1", enum.AUDIO, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Id != first.Id || again.State != string(enum.QUEUED) || again.Attempts != 0 {
+		t.Fatalf("expected the finished job to be queued again, got %+v", again)
+	}
+}
+
+func TestEnqueueReplaceJob_RequeuesFinishedJobAsReplacement(t *testing.T) {
+	setupTestDB(t)
+
+	job, err := EnqueueDownloadJob("video1", enum.AUDIO, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.State = string(enum.DONE)
+	if err := UpdateDownloadJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	replace, err := EnqueueReplaceJob("video1", enum.AUDIO, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replace.Id != job.Id || replace.State != string(enum.QUEUED) || !replace.Replace {
+		t.Fatalf("expected the finished job to be queued as a replacement, got %+v", replace)
+	}
+
+	replace.State = string(enum.DONE)
+	if err := UpdateDownloadJob(replace); err != nil {
+		t.Fatal(err)
+	}
+	again, err := EnqueueDownloadJob("video1", enum.AUDIO, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Replace {
+		t.Fatalf("expected a plain download to no longer replace, got %+v", again)
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"ikoyhn/podcast-sponsorblock/internal/config"
 	"ikoyhn/podcast-sponsorblock/internal/enum"
 	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
 	"os"
 	"path"
-	"strings"
 	"time"
 
 	"github.com/labstack/gommon/log"
@@ -40,6 +40,27 @@ func GetLatestEpisode(podcastId string) (*models.PodcastEpisode, error) {
 	return &episode, nil
 }
 
+// GetEpisodesUpdatedAt returns when an episode of a podcast last changed.
+func GetEpisodesUpdatedAt(podcastId string) time.Time {
+	var episode models.PodcastEpisode
+	err := db.Select("updated_at").Where("podcast_id = ?", podcastId).Order("updated_at DESC").First(&episode).Error
+	if err != nil {
+		return time.Time{}
+	}
+	return episode.UpdatedAt
+}
+
+// TouchEpisode marks an episode as changed, for state kept outside of its
+// row that its feed entry depends on.
+func TouchEpisode(youtubeVideoId string) {
+	err := db.Model(&models.PodcastEpisode{}).
+		Where("youtube_video_id = ?", youtubeVideoId).
+		Update("updated_at", time.Now()).Error
+	if err != nil {
+		log.Error("[DB] Failed to touch episode " + youtubeVideoId + ": " + err.Error())
+	}
+}
+
 func GetOldestEpisode(podcastId string) (*models.PodcastEpisode, error) {
 	var episode models.PodcastEpisode
 	err := db.Where("podcast_id = ?", podcastId).Order("published_date ASC").First(&episode).Error
@@ -78,57 +99,153 @@ func IsEpisodeSaved(item *ytApi.Video) bool {
 
 func GetPodcastEpisodesByPodcastId(podcastId string, podcastType enum.PodcastType) ([]models.PodcastEpisode, error) {
 	var episodes []models.PodcastEpisode
-	if podcastType == enum.PLAYLIST {
-		err := db.Where("podcast_id = ?", podcastId).
-			Order("published_date DESC").
-			Find(&episodes).Error
-		if err != nil {
-			return nil, err
-		}
-	} else if podcastType == enum.CHANNEL {
-		dur, err := time.ParseDuration(config.AppConfig.Ytdlp.EpisodeDurationMinimum)
-		if err != nil {
-			return nil, err
-		}
+	query, err := podcastEpisodesQuery(podcastId, podcastType)
+	if err != nil {
+		return nil, err
+	}
+	if err := query.Order("published_date DESC").Find(&episodes).Error; err != nil {
+		return nil, err
+	}
+	return episodes, nil
+}
 
-		err = db.Where("podcast_id = ? AND duration >= ?", podcastId, dur).
-			Order("published_date DESC").
-			Find(&episodes).Error
+// CountListedEpisodes counts the episodes of a podcast its feed lists before
+// its episode filter is applied.
+func CountListedEpisodes(podcastId string, podcastType enum.PodcastType) (int64, error) {
+	var count int64
+	query, err := listedEpisodesQuery(podcastId, podcastType)
+	if err != nil {
+		return 0, err
+	}
+	if err := query.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetListedEpisodes returns up to limit of the episodes of a podcast its feed
+// lists before its episode filter is applied, after skipping offset of them.
+// They are counted from the newest episode, or from the oldest one when
+// oldestFirst is set, and returned in that order.
+func GetListedEpisodes(podcastId string, podcastType enum.PodcastType, oldestFirst bool, offset int, limit int) ([]models.PodcastEpisode, error) {
+	var episodes []models.PodcastEpisode
+	query, err := listedEpisodesQuery(podcastId, podcastType)
+	if err != nil {
+		return nil, err
+	}
+	order := "published_date DESC, id DESC"
+	if oldestFirst {
+		order = "published_date ASC, id ASC"
+	}
+	if err := query.Order(order).Offset(offset).Limit(limit).Find(&episodes).Error; err != nil {
+		return nil, err
+	}
+	return episodes, nil
+}
+
+// listedEpisodesQuery narrows podcastEpisodesQuery to the episodes a feed
+// lists before its episode filter: private videos and channel videos under
+// two minutes are left out, as in rss.IsListed.
+func listedEpisodesQuery(podcastId string, podcastType enum.PodcastType) (*gorm.DB, error) {
+	query, err := podcastEpisodesQuery(podcastId, podcastType)
+	if err != nil {
+		return nil, err
+	}
+	return query.
+		Where("NOT (type = ? AND duration < ?)", string(enum.CHANNEL), 2*time.Minute).
+		Where("episode_name <> ? AND episode_description <> ?", "Private video", "This video is private."), nil
+}
+
+// podcastEpisodesQuery selects the episodes that belong in a podcast's feed.
+// Channel feeds leave out videos shorter than the configured minimum.
+func podcastEpisodesQuery(podcastId string, podcastType enum.PodcastType) (*gorm.DB, error) {
+	query := db.Model(&models.PodcastEpisode{}).Where("podcast_id = ?", podcastId)
+	if podcastType == enum.CHANNEL {
+		dur, err := time.ParseDuration(config.AppConfig.Ytdlp.EpisodeDurationMinimum)
 		if err != nil {
 			return nil, err
 		}
+		query = query.Where("duration >= ?", dur)
 	}
-
-	return episodes, nil
+	return query, nil
 }
 
+// DeletePodcastCronJob deletes the downloaded episodes the retention policy
+// of their podcast no longer keeps, then evicts the least recently played
+// episodes while the cache is over its size budget.
 func DeletePodcastCronJob() {
-	oneWeekAgo := time.Now().Add(-7 * 24 * time.Hour).Unix()
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
 
 	var histories []models.EpisodePlaybackHistory
-	db.Where("last_access_date < ?", oneWeekAgo).Find(&histories)
+	db.Find(&histories)
 
+	retention := newRetentionCheck(time.Now())
 	for _, history := range histories {
-		filePath := FindFileWithId(config.AppConfig.Setup.AudioDir, history.YoutubeVideoId)
-		if filePath == "" {
-			log.Debug("[DB] File not found when attempting to delete for video: " + history.YoutubeVideoId)
-		} else {
-			err := os.Remove(filePath)
-			if err != nil {
-				if os.IsNotExist(err) {
-					log.Debug("[DB] File not found when attempting to delete: " + filePath)
-				} else {
-					log.Warn("[DB] Failed to remove file: " + filePath + " error: " + err.Error())
-				}
-			}
+		if retention.keeps(history) {
+			continue
 		}
+		deleteCachedEpisode(history, false)
+	}
+
+	enforceCacheBudget()
+}
 
-		if delErr := db.Delete(&history).Error; delErr != nil {
-			log.Error("[DB] Failed to delete playback history for " + history.YoutubeVideoId + ": " + delErr.Error())
+// DeleteExpiredSourceAudio deletes the uncut source audio that was downloaded
+// longer ago than the source retention. A retention of 0 keeps it forever.
+func DeleteExpiredSourceAudio() {
+	days := config.AppConfig.Ytdlp.SourceRetentionDays
+	if days == 0 {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	files, err := storage.List(config.AppConfig.Setup.SourceDir, "")
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if file.ModTime.After(cutoff) {
 			continue
 		}
+		removeEpisodeFile(path.Join(config.AppConfig.Setup.SourceDir, file.Name))
+		log.Info("[DB] Deleted expired source audio... " + file.Name)
+	}
+}
+
+// RemoveEpisodeFiles deletes every cached file of a video, the audio and all
+// of its variants, along with how the audio was processed. The kept source
+// audio has its own retention and is left alone.
+func RemoveEpisodeFiles(youtubeVideoId string) {
+	for _, mediaDir := range EpisodeDirs() {
+		if filePath := FindFileWithId(mediaDir, youtubeVideoId); filePath != "" {
+			removeEpisodeFile(filePath)
+		}
+	}
+	if err := DeleteProcessedAudio(youtubeVideoId); err != nil {
+		log.Error(err)
+	}
+}
 
-		log.Info("[DB] Deleted old episode playback history... " + history.YoutubeVideoId)
+// EpisodeDirs returns every directory a downloaded or transcoded episode may
+// be cached in, those of the SponsorBlock category sets downloaded so far
+// included.
+func EpisodeDirs() []string {
+	dirs := config.EpisodeDirs()
+	for _, categories := range GetVariantCategories() {
+		dirs = append(dirs, config.VariantDir(enum.AUDIO, categories), config.VariantDir(enum.VIDEO, categories))
+	}
+	return dirs
+}
+
+func removeEpisodeFile(filePath string) {
+	err := storage.Remove(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Debug("[DB] File not found when attempting to delete: " + filePath)
+		} else {
+			log.Warn("[DB] Failed to remove file: " + filePath + " error: " + err.Error())
+		}
 	}
 }
 
@@ -141,28 +258,51 @@ func GetEpisodeByVideoId(videoId string) (*models.PodcastEpisode, error) {
 	return &episode, nil
 }
 
-func FindFileWithId(baseDir, videoId string) string {
-	entries, err := os.ReadDir(baseDir)
+// GetEpisodesPublishedSince returns the episodes of every podcast published
+// after the given time.
+func GetEpisodesPublishedSince(since time.Time) ([]models.PodcastEpisode, error) {
+	var episodes []models.PodcastEpisode
+	err := db.Where("published_date > ?", since).Find(&episodes).Error
+	return episodes, err
+}
+
+// UpdateEpisodeMediaInfo stores the container, codec and byte size of the
+// audio for every episode of the given video.
+func UpdateEpisodeMediaInfo(youtubeVideoId string, fileExtension string, audioCodec string, fileSize int64) {
+	err := db.Model(&models.PodcastEpisode{}).
+		Where("youtube_video_id = ?", youtubeVideoId).
+		Updates(map[string]interface{}{
+			"file_extension": fileExtension,
+			"audio_codec":    audioCodec,
+			"file_size":      fileSize,
+		}).Error
 	if err != nil {
-		return ""
-	}
-	for _, entry := range entries {
-		if strings.HasPrefix(entry.Name(), videoId+".") && !entry.IsDir() {
-			return path.Join(baseDir, entry.Name())
-		}
+		log.Error("[DB] Failed to update media info for " + youtubeVideoId + ": " + err.Error())
 	}
-	return ""
 }
 
-func FileExistsWithId(baseDir, videoId string) bool {
-	entries, err := os.ReadDir(baseDir)
+// GetEpisodesWithoutMediaInfo returns the newest episodes of a podcast that
+// have no container or size recorded yet.
+func GetEpisodesWithoutMediaInfo(podcastId string, limit int) ([]models.PodcastEpisode, error) {
+	var episodes []models.PodcastEpisode
+	err := db.Where("podcast_id = ? AND (file_size = 0 OR file_size IS NULL)", podcastId).
+		Order("published_date DESC").
+		Limit(limit).
+		Find(&episodes).Error
 	if err != nil {
-		return false
+		return nil, err
 	}
-	for _, entry := range entries {
-		if strings.HasPrefix(entry.Name(), videoId+".") && !entry.IsDir() {
-			return true
-		}
+	return episodes, nil
+}
+
+func FindFileWithId(baseDir, videoId string) string {
+	files, err := storage.List(baseDir, videoId+".")
+	if err != nil || len(files) == 0 {
+		return ""
 	}
-	return false
+	return path.Join(baseDir, files[0].Name)
+}
+
+func FileExistsWithId(baseDir, videoId string) bool {
+	return FindFileWithId(baseDir, videoId) != ""
 }
@@ -83,3 +83,56 @@ func TestDeletePodcastCronJob_DeletesRecordWhenFileMissing(t *testing.T) {
 		t.Fatalf("expected DB record to be deleted for missing file")
 	}
 }
+
+func TestDeleteExpiredSourceAudio(t *testing.T) {
+	tmp := setupTestDB(t)
+	config.AppConfig.Setup.SourceDir = path.Join(tmp, "source")
+	config.AppConfig.Ytdlp.SourceRetentionDays = 30
+	defer func() { config.AppConfig.Ytdlp.SourceRetentionDays = 0 }()
+	if err := os.MkdirAll(config.AppConfig.Setup.SourceDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	oldSource := path.Join(config.AppConfig.Setup.SourceDir, "old.m4a")
+	newSource := path.Join(config.AppConfig.Setup.SourceDir, "new.m4a")
+	for _, file := range []string{oldSource, newSource} {
+		if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expired := time.Now().AddDate(0, 0, -31)
+	if err := os.Chtimes(oldSource, expired, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	DeleteExpiredSourceAudio()
+
+	if _, err := os.Stat(oldSource); !os.IsNotExist(err) {
+		t.Error("expected the expired source to be deleted")
+	}
+	if _, err := os.Stat(newSource); err != nil {
+		t.Error("expected the recent source to be kept")
+	}
+}
+
+func TestTouchEpisodeBumpsEpisodesUpdatedAt(t *testing.T) {
+	setupTestDB(t)
+
+	episode := &models.PodcastEpisode{YoutubeVideoId: "video1", PodcastId: "podcast1"}
+	if err := db.Create(episode).Error; err != nil {
+		t.Fatal(err)
+	}
+	created := GetEpisodesUpdatedAt("podcast1")
+	if created.IsZero() {
+		t.Fatal("expected the stamp to be set on create")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	TouchEpisode("video1")
+	if touched := GetEpisodesUpdatedAt("podcast1"); !touched.After(created) {
+		t.Errorf("expected %v to be after %v", touched, created)
+	}
+	if !GetEpisodesUpdatedAt("other").IsZero() {
+		t.Error("expected no stamp for a podcast without episodes")
+	}
+}
@@ -0,0 +1,19 @@
+package database
+
+import "ikoyhn/podcast-sponsorblock/internal/models"
+
+// GetLoudnessMeasurement returns the stored loudness measurement of a video,
+// or nil when it hasn't been measured.
+func GetLoudnessMeasurement(youtubeVideoId string) *models.LoudnessMeasurement {
+	var measurement models.LoudnessMeasurement
+	if err := db.Where("youtube_video_id = ?", youtubeVideoId).First(&measurement).Error; err != nil {
+		return nil
+	}
+	return &measurement
+}
+
+// SaveLoudnessMeasurement stores the loudness measurement of a video,
+// replacing the previous one.
+func SaveLoudnessMeasurement(measurement *models.LoudnessMeasurement) error {
+	return db.Save(measurement).Error
+}
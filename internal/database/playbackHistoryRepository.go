@@ -4,16 +4,22 @@ import (
 	"ikoyhn/podcast-sponsorblock/internal/config"
 	"ikoyhn/podcast-sponsorblock/internal/models"
 	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/labstack/gommon/log"
 )
 
+// UpdateEpisodePlaybackHistory records that an episode was played, which
+// keeps it from being evicted, and the sponsor time its download skips.
 func UpdateEpisodePlaybackHistory(youtubeVideoId string, totalTimeSkipped float64) {
 	log.Info("[DB] Updating episode playback history...")
 	db.Model(&models.EpisodePlaybackHistory{}).
 		Where("youtube_video_id = ?", youtubeVideoId).
+		Assign(map[string]interface{}{"last_access_date": time.Now().Unix(), "total_time_skipped": totalTimeSkipped}).
 		FirstOrCreate(&models.EpisodePlaybackHistory{
 			YoutubeVideoId:   youtubeVideoId,
 			LastAccessDate:   time.Now().Unix(),
@@ -21,6 +27,19 @@ func UpdateEpisodePlaybackHistory(youtubeVideoId string, totalTimeSkipped float6
 		})
 }
 
+// UpdateEpisodeTimeSkipped records the segments an episode is cut with
+// without counting it as played.
+func UpdateEpisodeTimeSkipped(youtubeVideoId string, totalTimeSkipped float64) error {
+	err := db.Model(&models.EpisodePlaybackHistory{}).
+		Where("youtube_video_id = ?", youtubeVideoId).
+		Update("total_time_skipped", totalTimeSkipped).Error
+	if err != nil {
+		return err
+	}
+	TouchEpisode(youtubeVideoId)
+	return nil
+}
+
 func GetEpisodePlaybackHistory(youtubeVideoId string) *models.EpisodePlaybackHistory {
 	var history models.EpisodePlaybackHistory
 	db.Where("youtube_video_id = ?", youtubeVideoId).First(&history)
@@ -35,9 +54,11 @@ func TrackEpisodeFiles() {
 	if _, err := os.Stat(config.AppConfig.Setup.ConfigDir); os.IsNotExist(err) {
 		os.MkdirAll(config.AppConfig.Setup.ConfigDir, 0755)
 	}
-	files, err := os.ReadDir(config.AppConfig.Setup.AudioDir)
+	files, err := storage.List(config.AppConfig.Setup.AudioDir, "")
 	if err != nil {
+		// Without the files every history would look orphaned.
 		log.Error(err)
+		return
 	}
 
 	dbFiles := make([]string, 0)
@@ -46,13 +67,13 @@ func TrackEpisodeFiles() {
 	missingFiles := make([]string, 0)
 	nonExistentDbFiles := make([]string, 0)
 	for _, file := range files {
-		filename := file.Name()
+		filename := file.Name
 		if !common.IsValidFilename(filename) {
 			continue
 		}
 		found := false
 		for _, dbFile := range dbFiles {
-			if dbFile == filename[:len(filename)-4] {
+			if dbFile == common.TrimExtension(filename) {
 				found = true
 				break
 			}
@@ -65,7 +86,7 @@ func TrackEpisodeFiles() {
 	for _, dbFile := range dbFiles {
 		found := false
 		for _, file := range files {
-			if dbFile == file.Name()[:len(file.Name())-4] {
+			if dbFile == common.TrimExtension(file.Name) {
 				found = true
 				break
 			}
@@ -76,13 +97,21 @@ func TrackEpisodeFiles() {
 	}
 
 	for _, filename := range missingFiles {
-		id := filename[:len(filename)-4]
+		id := common.TrimExtension(filename)
 		if !common.IsValidID(id) {
 			continue
 		}
 		db.Create(&models.EpisodePlaybackHistory{YoutubeVideoId: id, LastAccessDate: time.Now().Unix(), TotalTimeSkipped: 0})
 	}
 
+	for _, file := range files {
+		if !common.IsValidFilename(file.Name) {
+			continue
+		}
+		ext := strings.TrimPrefix(filepath.Ext(file.Name), ".")
+		UpdateEpisodeMediaInfo(common.TrimExtension(file.Name), ext, common.AudioCodecFromExtension(ext), file.Size)
+	}
+
 	for _, dbFile := range nonExistentDbFiles {
 		if !common.IsValidID(dbFile) {
 			continue
@@ -2,6 +2,7 @@ package database
 
 import (
 	"ikoyhn/podcast-sponsorblock/internal/models"
+	"time"
 
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
@@ -37,6 +38,61 @@ func SavePodcast(podcast *models.Podcast) {
 	db.Create(&podcast)
 }
 
-func UpdatePodcast(podcast *models.Podcast) {
-	db.Save(podcast)
+func UpdatePodcast(podcast *models.Podcast) error {
+	return db.Save(podcast).Error
+}
+
+func GetAllPodcasts() ([]models.Podcast, error) {
+	var podcasts []models.Podcast
+	if err := db.Order("podcast_name ASC").Find(&podcasts).Error; err != nil {
+		return nil, err
+	}
+	return podcasts, nil
+}
+
+// UpdatePodcastMetadata replaces only the channel metadata of a podcast,
+// leaving its settings alone.
+func UpdatePodcastMetadata(podcast *models.Podcast) error {
+	podcast.UpdatedAt = time.Now()
+	return db.Model(&models.Podcast{}).Where("id = ?", podcast.Id).
+		Select("podcast_name", "description", "image_url", "posted_date", "artist_name", "explicit", "updated_at").
+		Updates(podcast).Error
+}
+
+// UpdatePodcastFilter replaces only the episode filter of a podcast, so a
+// concurrent feed refresh can't overwrite it with a stale copy.
+func UpdatePodcastFilter(podcastId string, filter models.EpisodeFilter) error {
+	return db.Model(&models.Podcast{}).Where("id = ?", podcastId).
+		Select("filter_include_title", "filter_exclude_title", "filter_include_description", "filter_exclude_description", "updated_at").
+		Updates(models.Podcast{Filter: filter, UpdatedAt: time.Now()}).Error
+}
+
+// UpdatePodcastAutoDownload replaces only the auto-download policy of a
+// podcast.
+func UpdatePodcastAutoDownload(podcastId string, policy models.AutoDownloadPolicy) error {
+	return db.Model(&models.Podcast{}).Where("id = ?", podcastId).
+		Select("auto_download_latest", "auto_download_newer_than_days", "updated_at").
+		Updates(models.Podcast{AutoDownload: policy, UpdatedAt: time.Now()}).Error
+}
+
+// UpdatePodcastAudioProfile sets the audio profile the podcast's episodes are
+// served in. An empty profile serves the downloaded audio.
+func UpdatePodcastAudioProfile(podcastId string, profile string) error {
+	return db.Model(&models.Podcast{}).Where("id = ?", podcastId).
+		Updates(map[string]interface{}{"audio_profile": profile, "updated_at": time.Now()}).Error
+}
+
+// UpdatePodcastSponsorBlock replaces only the SponsorBlock categories of a
+// podcast and whether their segments are cut or marked.
+func UpdatePodcastSponsorBlock(podcastId string, categories string, mode string) error {
+	return db.Model(&models.Podcast{}).Where("id = ?", podcastId).
+		Select("sponsorblock_categories", "sponsorblock_mode", "updated_at").
+		Updates(models.Podcast{SponsorBlockCategories: categories, SponsorBlockMode: mode, UpdatedAt: time.Now()}).Error
+}
+
+// UpdatePodcastProcessing replaces only the audio processing of a podcast.
+func UpdatePodcastProcessing(podcastId string, processing models.AudioProcessing) error {
+	return db.Model(&models.Podcast{}).Where("id = ?", podcastId).
+		Select("processing_remove_silence", "processing_tempo", "updated_at").
+		Updates(models.Podcast{Processing: processing, UpdatedAt: time.Now()}).Error
 }
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"ikoyhn/podcast-sponsorblock/internal/models"
+)
+
+func TestUpdatePodcastSettersBumpUpdatedAt(t *testing.T) {
+	setupTestDB(t)
+
+	if err := db.Create(&models.Podcast{Id: "podcast1"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	created := GetPodcast("podcast1").UpdatedAt
+	if created.IsZero() {
+		t.Fatal("expected the stamp to be set on create")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := UpdatePodcastAudioProfile("podcast1", "low"); err != nil {
+		t.Fatal(err)
+	}
+	profiled := GetPodcast("podcast1").UpdatedAt
+	if !profiled.After(created) {
+		t.Errorf("expected %v to be after %v", profiled, created)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := UpdatePodcastFilter("podcast1", models.EpisodeFilter{IncludeTitle: "news"}); err != nil {
+		t.Fatal(err)
+	}
+	if filtered := GetPodcast("podcast1"); !filtered.UpdatedAt.After(profiled) || filtered.AudioProfile != "low" {
+		t.Errorf("expected the filter to bump the stamp and keep the profile, got %+v", filtered)
+	}
+}
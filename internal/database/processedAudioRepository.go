@@ -0,0 +1,77 @@
+package database
+
+import "ikoyhn/podcast-sponsorblock/internal/models"
+
+// GetProcessedAudio returns how the cached audio of a video was processed,
+// or nil when it wasn't.
+func GetProcessedAudio(youtubeVideoId string) *models.ProcessedAudio {
+	var processed models.ProcessedAudio
+	if err := db.Where("youtube_video_id = ?", youtubeVideoId).First(&processed).Error; err != nil {
+		return nil
+	}
+	return &processed
+}
+
+// ProcessedTimeMapper returns the function that converts a timestamp in the
+// audio with the sponsor segments removed to the matching timestamp in the
+// processed audio. Until the audio has been processed only the tempo of the
+// podcast is taken into account.
+func ProcessedTimeMapper(youtubeVideoId string) func(float64) float64 {
+	if processed := GetProcessedAudio(youtubeVideoId); processed != nil {
+		return processed.MapTime
+	}
+	speed := GetPodcastProcessing(youtubeVideoId).Speed()
+	return func(timestamp float64) float64 {
+		return timestamp / speed
+	}
+}
+
+// GetPodcastProcessing returns the audio processing of the podcast the video
+// belongs to.
+func GetPodcastProcessing(youtubeVideoId string) models.AudioProcessing {
+	episode, err := GetEpisodeByVideoId(youtubeVideoId)
+	if err != nil {
+		return models.AudioProcessing{}
+	}
+	podcast := GetPodcast(episode.PodcastId)
+	if podcast == nil {
+		return models.AudioProcessing{}
+	}
+	return podcast.Processing
+}
+
+// GetProcessedAudios returns the processing records of the given videos
+// keyed by video ID.
+func GetProcessedAudios(youtubeVideoIds []string) map[string]*models.ProcessedAudio {
+	records := map[string]*models.ProcessedAudio{}
+	if len(youtubeVideoIds) == 0 {
+		return records
+	}
+	var processed []models.ProcessedAudio
+	if err := db.Where("youtube_video_id IN ?", youtubeVideoIds).Find(&processed).Error; err != nil {
+		return records
+	}
+	for i := range processed {
+		records[processed[i].YoutubeVideoId] = &processed[i]
+	}
+	return records
+}
+
+// SaveProcessedAudio stores how the cached audio of a video was processed.
+func SaveProcessedAudio(processed *models.ProcessedAudio) error {
+	if err := db.Save(processed).Error; err != nil {
+		return err
+	}
+	TouchEpisode(processed.YoutubeVideoId)
+	return nil
+}
+
+// DeleteProcessedAudio forgets the processing of a video, once its cached
+// audio is removed or replaced by a new download.
+func DeleteProcessedAudio(youtubeVideoId string) error {
+	if err := db.Where("youtube_video_id = ?", youtubeVideoId).Delete(&models.ProcessedAudio{}).Error; err != nil {
+		return err
+	}
+	TouchEpisode(youtubeVideoId)
+	return nil
+}
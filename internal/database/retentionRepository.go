@@ -0,0 +1,226 @@
+package database
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
+	"sync"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// defaultCacheExpiryDays is used when cache-expiry-days isn't configured.
+const defaultCacheExpiryDays = 7
+
+// latestScanBatchSize is how many episodes are read at a time while looking
+// for the newest ones a podcast keeps.
+const latestScanBatchSize = 200
+
+// cacheMu keeps the retention job and budget evictions from running at the
+// same time.
+var cacheMu sync.Mutex
+
+// UpdatePodcastRetention replaces only the retention policy of a podcast.
+func UpdatePodcastRetention(podcastId string, policy models.RetentionPolicy) error {
+	return db.Model(&models.Podcast{}).Where("id = ?", podcastId).
+		Select("retention_archive", "retention_keep_latest", "retention_expire_days", "updated_at").
+		Updates(models.Podcast{Retention: policy, UpdatedAt: time.Now()}).Error
+}
+
+// SetEpisodePinned pins or unpins an episode. Pinned episodes are never
+// deleted from the cache. It returns false when the episode is unknown.
+func SetEpisodePinned(youtubeVideoId string, pinned bool) (bool, error) {
+	result := db.Model(&models.PodcastEpisode{}).
+		Where("youtube_video_id = ?", youtubeVideoId).
+		Update("pinned", pinned)
+	return result.RowsAffected > 0, result.Error
+}
+
+// EnforceCacheBudget evicts the least recently played episodes until the
+// cache fits in the configured max-cache-size. Pinned episodes and episodes
+// of archived podcasts are never evicted.
+func EnforceCacheBudget() {
+	if config.AppConfig.Setup.MaxCacheBytes <= 0 {
+		return
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	enforceCacheBudget()
+}
+
+func enforceCacheBudget() {
+	budget := config.AppConfig.Setup.MaxCacheBytes
+	if budget <= 0 {
+		return
+	}
+	sizes, total := cachedFileSizesById()
+	if total <= budget {
+		return
+	}
+
+	var histories []models.EpisodePlaybackHistory
+	db.Order("last_access_date ASC").Find(&histories)
+
+	retention := newRetentionCheck(time.Now())
+	for _, history := range histories {
+		if total <= budget {
+			return
+		}
+		if sizes[history.YoutubeVideoId] == 0 || retention.protected(history.YoutubeVideoId) {
+			continue
+		}
+		log.Infof("[DB] Cache is over its budget, evicting %s...", history.YoutubeVideoId)
+		total -= sizes[history.YoutubeVideoId]
+		deleteCachedEpisode(history, true)
+	}
+	if total > budget {
+		log.Warn("[DB] Cache is still over its budget, the remaining episodes are pinned or archived")
+	}
+}
+
+// deleteCachedEpisode deletes the files and playback history of an episode,
+// and its kept source audio when withSource is set.
+func deleteCachedEpisode(history models.EpisodePlaybackHistory, withSource bool) {
+	RemoveEpisodeFiles(history.YoutubeVideoId)
+	if withSource {
+		if source := FindFileWithId(config.AppConfig.Setup.SourceDir, history.YoutubeVideoId); source != "" {
+			removeEpisodeFile(source)
+		}
+	}
+
+	db.Where("youtube_video_id = ? AND state = ?", history.YoutubeVideoId, string(enum.DONE)).Delete(&models.DownloadJob{})
+	if delErr := db.Delete(&history).Error; delErr != nil {
+		log.Error("[DB] Failed to delete playback history for " + history.YoutubeVideoId + ": " + delErr.Error())
+		return
+	}
+	log.Info("[DB] Deleted old episode playback history... " + history.YoutubeVideoId)
+}
+
+// cachedFileSizesById returns the disk space used by every cached video,
+// kept source audio included, and the total.
+func cachedFileSizesById() (map[string]int64, int64) {
+	sizes := map[string]int64{}
+	total := int64(0)
+	for _, dir := range append(EpisodeDirs(), config.AppConfig.Setup.SourceDir) {
+		files, err := storage.List(dir, "")
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			total += file.Size
+			sizes[common.TrimExtension(file.Name)] += file.Size
+		}
+	}
+	return sizes, total
+}
+
+// retentionCheck applies the retention policies of the podcasts, loading each
+// podcast and its newest episodes only once per run.
+type retentionCheck struct {
+	now      time.Time
+	podcasts map[string]*models.Podcast
+	latest   map[string]map[string]bool
+}
+
+func newRetentionCheck(now time.Time) *retentionCheck {
+	return &retentionCheck{
+		now:      now,
+		podcasts: map[string]*models.Podcast{},
+		latest:   map[string]map[string]bool{},
+	}
+}
+
+// keeps reports whether the downloaded episode stays in the cache.
+func (r *retentionCheck) keeps(history models.EpisodePlaybackHistory) bool {
+	episode, podcast := r.lookup(history.YoutubeVideoId)
+	if episode != nil && episode.Pinned {
+		return true
+	}
+	policy := models.RetentionPolicy{}
+	isLatest := false
+	if podcast != nil {
+		policy = podcast.Retention
+		if policy.KeepLatest > 0 {
+			isLatest = r.newestEpisodes(podcast, policy.KeepLatest)[history.YoutubeVideoId]
+		}
+	}
+	return retains(policy, time.Unix(history.LastAccessDate, 0), isLatest, r.now, config.AppConfig.Setup.CacheExpiryDays)
+}
+
+// protected reports whether the episode may never be evicted.
+func (r *retentionCheck) protected(youtubeVideoId string) bool {
+	episode, podcast := r.lookup(youtubeVideoId)
+	return (episode != nil && episode.Pinned) || (podcast != nil && podcast.Retention.Archive)
+}
+
+func (r *retentionCheck) lookup(youtubeVideoId string) (*models.PodcastEpisode, *models.Podcast) {
+	var episode models.PodcastEpisode
+	if err := db.Where("youtube_video_id = ?", youtubeVideoId).First(&episode).Error; err != nil {
+		return nil, nil
+	}
+	podcast, ok := r.podcasts[episode.PodcastId]
+	if !ok {
+		podcast = GetPodcast(episode.PodcastId)
+		r.podcasts[episode.PodcastId] = podcast
+	}
+	return &episode, podcast
+}
+
+// newestEpisodes returns the newest limit episodes the podcast's feed lists,
+// so Shorts, private videos and episodes its filter leaves out don't take
+// the place of an episode that is kept.
+func (r *retentionCheck) newestEpisodes(podcast *models.Podcast, limit int) map[string]bool {
+	if latest, ok := r.latest[podcast.Id]; ok {
+		return latest
+	}
+	latest := map[string]bool{}
+	r.latest[podcast.Id] = latest
+
+	matcher, err := models.CompileEpisodeFilter(podcast.Filter)
+	if err != nil {
+		// A nil matcher passes every episode.
+		log.Warnf("[DB] Ignoring the invalid filter of %s: %v", podcast.Id, err)
+	}
+	for offset := 0; len(latest) < limit; offset += latestScanBatchSize {
+		batch, err := GetListedEpisodes(podcast.Id, podcast.PodcastType(), false, offset, latestScanBatchSize)
+		if err != nil {
+			log.Error(err)
+			break
+		}
+		for _, episode := range batch {
+			if len(latest) < limit && matcher.Matches(episode) {
+				latest[episode.YoutubeVideoId] = true
+			}
+		}
+		if len(batch) < latestScanBatchSize {
+			break
+		}
+	}
+	return latest
+}
+
+// retains decides with a retention policy whether an episode last played at
+// lastAccess is kept. isLatest tells whether it is one of the newest
+// KeepLatest episodes of its podcast.
+func retains(policy models.RetentionPolicy, lastAccess time.Time, isLatest bool, now time.Time, defaultExpiryDays int) bool {
+	if policy.Archive {
+		return true
+	}
+	if policy.KeepLatest > 0 && !isLatest {
+		return false
+	}
+	expireDays := policy.ExpireDays
+	if expireDays == 0 {
+		if policy.KeepLatest > 0 {
+			return true
+		}
+		expireDays = defaultExpiryDays
+		if expireDays <= 0 {
+			expireDays = defaultCacheExpiryDays
+		}
+	}
+	return lastAccess.After(now.AddDate(0, 0, -expireDays))
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+)
+
+func TestRetains(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	recent := now.AddDate(0, 0, -3)
+	old := now.AddDate(0, 0, -10)
+
+	cases := []struct {
+		name     string
+		policy   models.RetentionPolicy
+		access   time.Time
+		isLatest bool
+		expected bool
+	}{
+		{"default keeps recently played", models.RetentionPolicy{}, recent, false, true},
+		{"default expires after a week", models.RetentionPolicy{}, old, false, false},
+		{"archive keeps everything", models.RetentionPolicy{Archive: true}, old, false, true},
+		{"latest keeps newest", models.RetentionPolicy{KeepLatest: 3}, old, true, true},
+		{"latest drops older", models.RetentionPolicy{KeepLatest: 3}, recent, false, false},
+		{"latest and expiry need both", models.RetentionPolicy{KeepLatest: 3, ExpireDays: 5}, old, true, false},
+		{"custom expiry", models.RetentionPolicy{ExpireDays: 30}, old, false, true},
+	}
+	for _, c := range cases {
+		if got := retains(c.policy, c.access, c.isLatest, now, 7); got != c.expected {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestEnforceCacheBudget_EvictsLeastRecentlyPlayed(t *testing.T) {
+	setupTestDB(t)
+	config.AppConfig.Setup.MaxCacheBytes = 250
+	defer func() { config.AppConfig.Setup.MaxCacheBytes = 0 }()
+
+	now := time.Now()
+	for i, videoId := range []string{"oldest", "pinned", "newest"} {
+		if err := os.WriteFile(path.Join(config.AppConfig.Setup.AudioDir, videoId+".m4a"), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		db.Create(&models.EpisodePlaybackHistory{YoutubeVideoId: videoId, LastAccessDate: now.Add(time.Duration(i) * time.Hour).Unix()})
+		db.Create(&models.PodcastEpisode{YoutubeVideoId: videoId, PodcastId: "podcast"})
+	}
+	if found, err := SetEpisodePinned("pinned", true); err != nil || !found {
+		t.Fatalf("failed to pin episode: %v", err)
+	}
+	// Played before the others, but pinned.
+	db.Model(&models.EpisodePlaybackHistory{}).Where("youtube_video_id = ?", "pinned").Update("last_access_date", now.Add(-time.Hour).Unix())
+
+	EnforceCacheBudget()
+
+	for videoId, kept := range map[string]bool{"oldest": false, "pinned": true, "newest": true} {
+		if FileExistsWithId(config.AppConfig.Setup.AudioDir, videoId) != kept {
+			t.Errorf("expected %s kept=%v", videoId, kept)
+		}
+	}
+}
+
+func TestNewestEpisodes_SkipsUnlistedEpisodes(t *testing.T) {
+	setupTestDB(t)
+
+	podcast := &models.Podcast{Id: "PLpodcast", Type: "PLAYLIST", Filter: models.EpisodeFilter{ExcludeTitle: "clip"}}
+	now := time.Now()
+	for i, name := range []string{"Episode 1", "Episode 2", "Private video", "Best clip", "Episode 3"} {
+		db.Create(&models.PodcastEpisode{
+			YoutubeVideoId: fmt.Sprintf("video%d", i),
+			PodcastId:      podcast.Id,
+			EpisodeName:    name,
+			PublishedDate:  now.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	latest := newRetentionCheck(now).newestEpisodes(podcast, 2)
+	if len(latest) != 2 || !latest["video4"] || !latest["video1"] {
+		t.Errorf("expected video4 and video1 to be the newest listed episodes, got %v", latest)
+	}
+}
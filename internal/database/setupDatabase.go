@@ -49,4 +49,20 @@ func SetupDatabase() {
 	if err != nil {
 		panic(err)
 	}
+	err = db.AutoMigrate(&models.DownloadJob{})
+	if err != nil {
+		panic(err)
+	}
+	err = db.AutoMigrate(&models.LoudnessMeasurement{})
+	if err != nil {
+		panic(err)
+	}
+	err = db.AutoMigrate(&models.ProcessedAudio{})
+	if err != nil {
+		panic(err)
+	}
+	err = db.AutoMigrate(&models.SponsorSegmentCache{})
+	if err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"time"
+)
+
+// GetSponsorSegmentCache returns the cached SponsorBlock segments of a video,
+// or nil when they haven't been fetched.
+func GetSponsorSegmentCache(youtubeVideoId string) *models.SponsorSegmentCache {
+	var cache models.SponsorSegmentCache
+	if err := db.Where("youtube_video_id = ?", youtubeVideoId).First(&cache).Error; err != nil {
+		return nil
+	}
+	return &cache
+}
+
+// SaveSponsorSegmentCache stores the fetched SponsorBlock segments of a
+// video, replacing the previous ones.
+func SaveSponsorSegmentCache(cache *models.SponsorSegmentCache) error {
+	return db.Save(cache).Error
+}
+
+// DeleteSponsorSegmentCacheBefore deletes the segments fetched before the
+// cutoff, which are too old to be used even as a fallback.
+func DeleteSponsorSegmentCacheBefore(cutoff time.Time) error {
+	return db.Where("fetched_at < ?", cutoff).Delete(&models.SponsorSegmentCache{}).Error
+}
@@ -0,0 +1,9 @@
+package enum
+
+type FeedFormat string
+
+const (
+	RSS       FeedFormat = "rss"
+	ATOM      FeedFormat = "atom"
+	JSON_FEED FeedFormat = "json"
+)
@@ -0,0 +1,10 @@
+package enum
+
+type JobState string
+
+const (
+	QUEUED  JobState = "QUEUED"
+	RUNNING JobState = "RUNNING"
+	FAILED  JobState = "FAILED"
+	DONE    JobState = "DONE"
+)
@@ -0,0 +1,8 @@
+package enum
+
+type MediaType string
+
+const (
+	AUDIO MediaType = "audio"
+	VIDEO MediaType = "video"
+)
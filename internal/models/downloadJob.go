@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// DownloadJob is a queued download of the audio or video of an episode, or a
+// transcode of its audio with an audio profile, or a download of its audio
+// cut with other SponsorBlock categories than the podcast's. There is at most
+// one job per video, media type, profile and categories.
+type DownloadJob struct {
+	Id             uint   `json:"id" gorm:"primaryKey"`
+	YoutubeVideoId string `json:"youtube_video_id" gorm:"uniqueIndex:idx_download_job_key;not null"`
+	Media          string `json:"media" gorm:"uniqueIndex:idx_download_job_key;not null"`
+	Profile        string `json:"profile" gorm:"uniqueIndex:idx_download_job_key;not null;default:''"`
+	Categories     string `json:"categories" gorm:"uniqueIndex:idx_download_job_key;not null;default:''"`
+	// Replace downloads a cached file again and swaps it in once done, so
+	// the outdated file keeps being served meanwhile.
+	Replace       bool      `json:"replace"`
+	State         string    `json:"state" gorm:"index"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// EpisodeMatcher decides whether an episode is listed in a feed. Matching is
+// case-insensitive.
+type EpisodeMatcher struct {
+	includeTitle       *regexp.Regexp
+	excludeTitle       *regexp.Regexp
+	includeDescription *regexp.Regexp
+	excludeDescription *regexp.Regexp
+}
+
+// CompileEpisodeFilter compiles the expressions of an EpisodeFilter.
+func CompileEpisodeFilter(filter EpisodeFilter) (*EpisodeMatcher, error) {
+	matcher := &EpisodeMatcher{}
+	for _, field := range []struct {
+		name    string
+		pattern string
+		target  **regexp.Regexp
+	}{
+		{"include_title", filter.IncludeTitle, &matcher.includeTitle},
+		{"exclude_title", filter.ExcludeTitle, &matcher.excludeTitle},
+		{"include_description", filter.IncludeDescription, &matcher.includeDescription},
+		{"exclude_description", filter.ExcludeDescription, &matcher.excludeDescription},
+	} {
+		if field.pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + field.pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s expression", field.name)
+		}
+		*field.target = re
+	}
+	return matcher, nil
+}
+
+// IsEmpty reports whether the matcher passes every episode.
+func (m *EpisodeMatcher) IsEmpty() bool {
+	return m == nil || (m.includeTitle == nil && m.excludeTitle == nil && m.includeDescription == nil && m.excludeDescription == nil)
+}
+
+// Matches reports whether the episode passes every include expression and
+// none of the exclude expressions.
+func (m *EpisodeMatcher) Matches(episode PodcastEpisode) bool {
+	if m == nil {
+		return true
+	}
+	if m.includeTitle != nil && !m.includeTitle.MatchString(episode.EpisodeName) {
+		return false
+	}
+	if m.includeDescription != nil && !m.includeDescription.MatchString(episode.EpisodeDescription) {
+		return false
+	}
+	if m.excludeTitle != nil && m.excludeTitle.MatchString(episode.EpisodeName) {
+		return false
+	}
+	if m.excludeDescription != nil && m.excludeDescription.MatchString(episode.EpisodeDescription) {
+		return false
+	}
+	return true
+}
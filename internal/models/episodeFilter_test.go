@@ -0,0 +1,9 @@
+package models
+
+import "testing"
+
+func TestCompileEpisodeFilter_InvalidExpression(t *testing.T) {
+	if _, err := CompileEpisodeFilter(EpisodeFilter{ExcludeTitle: "(unclosed"}); err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// LoudnessMeasurement holds the EBU R128 values ffmpeg's loudnorm filter
+// measured on a downloaded episode, and the targets it was normalized to.
+// FileSize identifies the normalized file, so a new download is measured
+// again while an unchanged one is skipped.
+type LoudnessMeasurement struct {
+	YoutubeVideoId string    `json:"youtube_video_id" gorm:"primaryKey"`
+	InputI         float64   `json:"input_i"`
+	InputTP        float64   `json:"input_tp"`
+	InputLRA       float64   `json:"input_lra"`
+	InputThresh    float64   `json:"input_thresh"`
+	TargetOffset   float64   `json:"target_offset"`
+	TargetLufs     float64   `json:"target_lufs"`
+	TruePeak       float64   `json:"true_peak"`
+	FileSize       int64     `json:"file_size"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
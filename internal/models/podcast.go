@@ -2,6 +2,7 @@ package models
 
 import (
 	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"strings"
 	"time"
 
 	log "github.com/labstack/gommon/log"
@@ -18,20 +19,108 @@ type PodcastEpisode struct {
 	PodcastId          string        `json:"podcast_id" gorm:"foreignkey:PodcastId;association_foreignkey:Id"`
 	ImageUrl           string        `json:"image_url"`
 	Duration           time.Duration `json:"duration"`
+	FileExtension      string        `json:"file_extension"`
+	AudioCodec         string        `json:"audio_codec"`
+	FileSize           int64         `json:"file_size"`
+	Pinned             bool          `json:"pinned"`
+	// UpdatedAt changes whenever anything a feed shows about the episode
+	// changes, such as its media info, processing, cut or transcript.
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type Podcast struct {
-	AppleId         string           `json:"apple_id"`
-	Id              string           `json:"id" gorm:"primary_key"`
-	PodcastName     string           `json:"podcast_name"`
-	Description     string           `json:"description"`
-	Category        string           `json:"category"`
-	PostedDate      string           `json:"posted_date"`
-	ImageUrl        string           `json:"image_url"`
-	LastBuildDate   string           `json:"last_build_date"`
-	PodcastEpisodes []PodcastEpisode `json:"podcast_episodes"`
-	ArtistName      string           `json:"artist_name"`
-	Explicit        string           `json:"explicit"`
+	AppleId         string             `json:"apple_id"`
+	Id              string             `json:"id" gorm:"primary_key"`
+	PodcastName     string             `json:"podcast_name"`
+	Description     string             `json:"description"`
+	Category        string             `json:"category"`
+	PostedDate      string             `json:"posted_date"`
+	ImageUrl        string             `json:"image_url"`
+	LastBuildDate   string             `json:"last_build_date"`
+	PodcastEpisodes []PodcastEpisode   `json:"podcast_episodes"`
+	ArtistName      string             `json:"artist_name"`
+	Explicit        string             `json:"explicit"`
+	Type            string             `json:"type"`
+	Filter          EpisodeFilter      `json:"filter" gorm:"embedded;embeddedPrefix:filter_"`
+	AutoDownload    AutoDownloadPolicy `json:"auto_download" gorm:"embedded;embeddedPrefix:auto_download_"`
+	AudioProfile    string             `json:"audio_profile"`
+	Processing      AudioProcessing    `json:"audio_processing" gorm:"embedded;embeddedPrefix:processing_"`
+	Retention       RetentionPolicy    `json:"retention" gorm:"embedded;embeddedPrefix:retention_"`
+	// SponsorBlockCategories are the categories cut from the episodes, comma
+	// separated, empty for the configured ones.
+	SponsorBlockCategories string `json:"sponsorblock_categories" gorm:"column:sponsorblock_categories"`
+	// SponsorBlockMode is "mark" when the segments are kept and marked with
+	// chapters instead of cut, empty to cut them.
+	SponsorBlockMode string `json:"sponsorblock_mode" gorm:"column:sponsorblock_mode"`
+	// UpdatedAt changes whenever the podcast or one of its settings does.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EpisodeFilter holds the regular expressions deciding which episodes are
+// listed in a feed. Empty expressions are ignored.
+type EpisodeFilter struct {
+	IncludeTitle       string `json:"include_title"`
+	ExcludeTitle       string `json:"exclude_title"`
+	IncludeDescription string `json:"include_description"`
+	ExcludeDescription string `json:"exclude_description"`
+}
+
+// AutoDownloadPolicy decides which episodes are downloaded as soon as a feed
+// refresh finds new videos, instead of when they are first played. When both
+// limits are set an episode has to meet both. The zero value downloads
+// nothing.
+type AutoDownloadPolicy struct {
+	Latest        int `json:"latest"`
+	NewerThanDays int `json:"newer_than_days"`
+}
+
+// Enabled reports whether the policy downloads anything.
+func (p AutoDownloadPolicy) Enabled() bool {
+	return p.Latest > 0 || p.NewerThanDays > 0
+}
+
+// AudioProcessing is applied to the downloaded audio of a podcast for
+// players without speed control: long silences are removed and the tempo is
+// changed with the pitch preserved. A Tempo of 0 or 1 keeps the speed.
+type AudioProcessing struct {
+	RemoveSilence bool    `json:"remove_silence"`
+	Tempo         float64 `json:"tempo"`
+}
+
+// Enabled reports whether the audio is changed at all.
+func (p AudioProcessing) Enabled() bool {
+	return p.RemoveSilence || p.Speed() != 1
+}
+
+// Speed returns the tempo factor, 1 when the tempo is unchanged.
+func (p AudioProcessing) Speed() float64 {
+	if p.Tempo <= 0 {
+		return 1
+	}
+	return p.Tempo
+}
+
+// RetentionPolicy decides how long the downloaded episodes of a podcast are
+// kept. Archive keeps every episode. Otherwise KeepLatest keeps only the
+// newest N episodes and ExpireDays deletes episodes that haven't been played
+// for X days; when both are set an episode has to meet both. The zero value
+// expires episodes after the configured cache-expiry-days.
+type RetentionPolicy struct {
+	Archive    bool `json:"archive"`
+	KeepLatest int  `json:"keep_latest"`
+	ExpireDays int  `json:"expire_days"`
+}
+
+// PodcastType returns the stored type of the podcast, falling back to the ID
+// format for podcasts saved before the type was recorded.
+func (p Podcast) PodcastType() enum.PodcastType {
+	if p.Type != "" {
+		return enum.PodcastType(p.Type)
+	}
+	if strings.HasPrefix(p.Id, "UC") {
+		return enum.CHANNEL
+	}
+	return enum.PLAYLIST
 }
 
 type EpisodePlaybackHistory struct {
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"testing"
+)
+
+func TestPodcastType_FallsBackToIdFormat(t *testing.T) {
+	if got := (Podcast{Id: "UCabc"}).PodcastType(); got != enum.CHANNEL {
+		t.Fatalf("expected CHANNEL, got %s", got)
+	}
+	if got := (Podcast{Id: "PLabc"}).PodcastType(); got != enum.PLAYLIST {
+		t.Fatalf("expected PLAYLIST, got %s", got)
+	}
+	if got := (Podcast{Id: "UCabc", Type: string(enum.PLAYLIST)}).PodcastType(); got != enum.PLAYLIST {
+		t.Fatalf("expected stored type to win, got %s", got)
+	}
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// ProcessedAudio records how the cached audio of an episode was processed,
+// so feeds, chapters and transcripts can be timed to the processed audio.
+// Silences are the [start, end] ranges removed, in seconds of the audio
+// before processing, sorted and not overlapping.
+type ProcessedAudio struct {
+	YoutubeVideoId string       `json:"youtube_video_id" gorm:"primaryKey"`
+	RemoveSilence  bool         `json:"remove_silence"`
+	Tempo          float64      `json:"tempo"`
+	Silences       [][2]float64 `json:"silences" gorm:"serializer:json"`
+	Duration       float64      `json:"duration"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// Settings returns the processing the audio went through.
+func (p *ProcessedAudio) Settings() AudioProcessing {
+	return AudioProcessing{RemoveSilence: p.RemoveSilence, Tempo: p.Tempo}
+}
+
+// MapTime converts a timestamp in the audio before processing to the
+// matching timestamp in the processed audio.
+func (p *ProcessedAudio) MapTime(timestamp float64) float64 {
+	removed := float64(0)
+	for _, silence := range p.Silences {
+		if silence[0] >= timestamp {
+			break
+		}
+		if timestamp < silence[1] {
+			removed += timestamp - silence[0]
+		} else {
+			removed += silence[1] - silence[0]
+		}
+	}
+	return (timestamp - removed) / p.Settings().Speed()
+}
@@ -1,10 +1,25 @@
 package models
 
 import (
+	"ikoyhn/podcast-sponsorblock/internal/enum"
 	"time"
 )
 
 type RssRequestParams struct {
-	Limit *int
-	Date  *time.Time
+	Limit  *int
+	Date   *time.Time
+	Format enum.FeedFormat
+	Page   *int
+	Filter EpisodeFilter
+	Media  enum.MediaType
+	// Profile is the audio profile enclosures are transcoded with, empty for
+	// the downloaded audio.
+	Profile string
+	// SponsorBlock is the set of categories cut from the enclosures, given
+	// with ?sb=, empty for the podcast's own.
+	SponsorBlock string
+	// SponsorBlockMode is "mark" when the segments are marked with chapters
+	// instead of cut and "cut" when they are cut, given with ?sb_mode=, empty
+	// for the podcast's own.
+	SponsorBlockMode string
 }
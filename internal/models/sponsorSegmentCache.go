@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// SponsorSegmentCache holds the SponsorBlock segments of a video as they were
+// last fetched, JSON encoded, so they aren't looked up on every request.
+type SponsorSegmentCache struct {
+	YoutubeVideoId string    `json:"youtube_video_id" gorm:"primaryKey"`
+	Segments       string    `json:"segments"`
+	FetchedAt      time.Time `json:"fetched_at"`
+}
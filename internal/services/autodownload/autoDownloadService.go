@@ -0,0 +1,83 @@
+package autodownload
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/downloader"
+	"ikoyhn/podcast-sponsorblock/internal/services/rss"
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"time"
+
+	log "github.com/labstack/gommon/log"
+)
+
+// Run queues, in the background, the download of every episode selected by
+// the podcast's auto-download policy, so it is cut and cached before a
+// podcast app asks for it. Episodes that are already queued are joined by the
+// download queue.
+func Run(podcastId string, podcastType enum.PodcastType) {
+	podcast := database.GetPodcast(podcastId)
+	if podcast == nil || !podcast.AutoDownload.Enabled() {
+		return
+	}
+
+	go func() {
+		episodes, err := database.GetPodcastEpisodesByPodcastId(podcastId, podcastType)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		matcher, err := models.CompileEpisodeFilter(podcast.Filter)
+		if err != nil {
+			log.Warnf("[AUTO DOWNLOAD] Ignoring episode filter of %s: %v", podcastId, err)
+		}
+
+		selected := SelectEpisodes(episodes, podcast.AutoDownload, matcher, time.Now())
+		log.Infof("[AUTO DOWNLOAD] Queueing %d episodes of %s...", len(selected), podcast.PodcastName)
+		// Podcasts that mark their segments are served the marked variant.
+		variant := sponsorblock.VariantKey(podcast, "", "")
+		for _, episode := range selected {
+			if variant != "" {
+				if !database.FileExistsWithId(config.VariantDir(enum.AUDIO, variant), episode.YoutubeVideoId) {
+					database.UpdateEpisodePlaybackHistory(episode.YoutubeVideoId, sponsorblock.TotalSponsorTimeSkipped(episode.YoutubeVideoId))
+					downloader.GetYoutubeMediaVariant(episode.YoutubeVideoId, enum.AUDIO, variant)
+				}
+				continue
+			}
+			if !database.FileExistsWithId(config.AppConfig.Setup.AudioDir, episode.YoutubeVideoId) {
+				// Record the segments the download is cut with, so the first
+				// play doesn't treat the file as outdated and download it again.
+				database.UpdateEpisodePlaybackHistory(episode.YoutubeVideoId, sponsorblock.TotalSponsorTimeSkipped(episode.YoutubeVideoId))
+				downloader.GetYoutubeVideo(episode.YoutubeVideoId)
+			}
+			if _, ok := config.AppConfig.AudioProfiles[podcast.AudioProfile]; ok && !database.FileExistsWithId(config.ProfileDir(podcast.AudioProfile), episode.YoutubeVideoId) {
+				downloader.GetYoutubeAudioProfile(episode.YoutubeVideoId, podcast.AudioProfile)
+			}
+		}
+	}()
+}
+
+// SelectEpisodes returns the episodes, ordered newest first, that the policy
+// downloads. Only episodes listed in the feed are considered.
+func SelectEpisodes(episodes []models.PodcastEpisode, policy models.AutoDownloadPolicy, matcher *models.EpisodeMatcher, now time.Time) []models.PodcastEpisode {
+	var selected []models.PodcastEpisode
+	if !policy.Enabled() {
+		return selected
+	}
+
+	for _, episode := range episodes {
+		if !rss.IsListed(episode, matcher) {
+			continue
+		}
+		if policy.NewerThanDays > 0 && episode.PublishedDate.Before(now.AddDate(0, 0, -policy.NewerThanDays)) {
+			continue
+		}
+		selected = append(selected, episode)
+		if policy.Latest > 0 && len(selected) == policy.Latest {
+			break
+		}
+	}
+	return selected
+}
@@ -0,0 +1,51 @@
+package autodownload
+
+import (
+	"testing"
+	"time"
+
+	"ikoyhn/podcast-sponsorblock/internal/models"
+)
+
+func TestSelectEpisodes(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	episodes := []models.PodcastEpisode{
+		{YoutubeVideoId: "new", EpisodeName: "Episode 3", PublishedDate: now.AddDate(0, 0, -1), Duration: time.Hour},
+		{YoutubeVideoId: "clip", EpisodeName: "Best clip", PublishedDate: now.AddDate(0, 0, -2), Duration: time.Hour},
+		{YoutubeVideoId: "private", EpisodeName: "Private video", PublishedDate: now.AddDate(0, 0, -3)},
+		{YoutubeVideoId: "week", EpisodeName: "Episode 2", PublishedDate: now.AddDate(0, 0, -6), Duration: time.Hour},
+		{YoutubeVideoId: "old", EpisodeName: "Episode 1", PublishedDate: now.AddDate(0, 0, -30), Duration: time.Hour},
+	}
+	matcher, err := models.CompileEpisodeFilter(models.EpisodeFilter{ExcludeTitle: "clip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		policy models.AutoDownloadPolicy
+		want   []string
+	}{
+		{"disabled", models.AutoDownloadPolicy{}, nil},
+		{"latest", models.AutoDownloadPolicy{Latest: 2}, []string{"new", "week"}},
+		{"newer than", models.AutoDownloadPolicy{NewerThanDays: 7}, []string{"new", "week"}},
+		{"both", models.AutoDownloadPolicy{Latest: 1, NewerThanDays: 7}, []string{"new"}},
+		{"latest beyond list", models.AutoDownloadPolicy{Latest: 10}, []string{"new", "week", "old"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, episode := range SelectEpisodes(episodes, tc.policy, matcher, now) {
+				got = append(got, episode.YoutubeVideoId)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("got %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
@@ -2,11 +2,12 @@ package channel
 
 import (
 	"errors"
-	"ikoyhn/podcast-sponsorblock/internal/config"
 	"ikoyhn/podcast-sponsorblock/internal/database"
 	"ikoyhn/podcast-sponsorblock/internal/enum"
 	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/autodownload"
 	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"ikoyhn/podcast-sponsorblock/internal/services/downloader"
 	"ikoyhn/podcast-sponsorblock/internal/services/rss"
 	"ikoyhn/podcast-sponsorblock/internal/services/youtube"
 	"time"
@@ -22,77 +23,78 @@ func BuildChannelRssFeed(channelId string, params *models.RssRequestParams, host
 	log.Debug("[RSS FEED] Building rss feed for channel...")
 	dbPodcast := database.GetPodcast(channelId)
 
-	shouldUpdate := true
-	if dbPodcast != nil && dbPodcast.LastBuildDate != "" {
-		dur, err := time.ParseDuration(config.AppConfig.Setup.PodcastRefreshInterval)
-		if err != nil {
-			panic("Invalid [podcast-refresh-interval] format. Use formats like '5m', '1h', '400s'.")
-		}
-		lastBuild, err := time.Parse(time.RFC1123, dbPodcast.LastBuildDate)
-		if err == nil && time.Since(lastBuild) < dur {
-			shouldUpdate = false
-			log.Infof("[YOUTUBE API] Skipping channel update, last build date within %v", dur)
+	if youtube.ShouldRefresh(dbPodcast) {
+		if youtube.GetChannelData(dbPodcast, channelId, false) != nil {
+			if getChannelMetadataAndVideos(channelId, params) > 0 {
+				autodownload.Run(channelId, enum.CHANNEL)
+			}
+			downloader.EstimateMediaInfo(channelId)
 		}
-	}
-
-	if shouldUpdate {
-		dbPodcast = youtube.GetChannelData(dbPodcast, channelId, false)
-		getChannelMetadataAndVideos(channelId, params)
 		dbPodcast = database.GetPodcast(channelId)
 	}
+	if dbPodcast == nil {
+		log.Errorf("[RSS FEED] Unable to find podcast %s", channelId)
+		return nil
+	}
 
-	episodes, err := database.GetPodcastEpisodesByPodcastId(channelId, enum.CHANNEL)
+	episodes, paging, err := rss.GetFeedEpisodes(*dbPodcast, enum.CHANNEL, params)
 	if err != nil {
 		log.Error(err)
 		return nil
 	}
 
 	podcastRss := rss.BuildPodcast(*dbPodcast, episodes)
-	return rss.GenerateRssFeed(podcastRss, host, enum.CHANNEL)
+	return rss.GenerateRssFeed(podcastRss, host, enum.CHANNEL, params, paging)
 }
 
-func getChannelMetadataAndVideos(channelId string, params *models.RssRequestParams) {
+// getChannelMetadataAndVideos saves the channel videos that are not stored yet
+// and returns how many new episodes were found.
+func getChannelMetadataAndVideos(channelId string, params *models.RssRequestParams) int {
 	log.Info("[RSS FEED] Getting channel data...")
 
 	if !youtube.FindChannel(channelId) {
-		return
+		return 0
 	}
 	oldestSavedEpisode, err := database.GetOldestEpisode(channelId)
 	latestSavedEpisode, err := database.GetLatestEpisode(channelId)
 
+	newEpisodes := 0
 	switch determineRequestType(params) {
 	case enum.DATE:
 		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-			return
+			return 0
 		}
 		if oldestSavedEpisode != nil {
 			if latestSavedEpisode.PublishedDate.After(*params.Date) {
-				getChannelVideosByDateRange(channelId, time.Now(), *params.Date)
+				newEpisodes += getChannelVideosByDateRange(channelId, time.Now(), *params.Date)
 			} else if oldestSavedEpisode.PublishedDate.After(*params.Date) {
-				getChannelVideosByDateRange(channelId, oldestSavedEpisode.PublishedDate, *params.Date)
-				getChannelVideosByDateRange(channelId, time.Now(), latestSavedEpisode.PublishedDate)
+				newEpisodes += getChannelVideosByDateRange(channelId, oldestSavedEpisode.PublishedDate, *params.Date)
+				newEpisodes += getChannelVideosByDateRange(channelId, time.Now(), latestSavedEpisode.PublishedDate)
 			}
 		} else {
-			getChannelVideosByDateRange(channelId, time.Now(), *params.Date)
+			newEpisodes += getChannelVideosByDateRange(channelId, time.Now(), *params.Date)
 		}
 	case enum.DEFAULT:
 		if (oldestSavedEpisode != nil) && (latestSavedEpisode != nil) {
-			getChannelVideosByDateRange(channelId, time.Now(), latestSavedEpisode.PublishedDate)
-			getChannelVideosByDateRange(channelId, oldestSavedEpisode.PublishedDate, time.Unix(0, 0))
+			newEpisodes += getChannelVideosByDateRange(channelId, time.Now(), latestSavedEpisode.PublishedDate)
+			newEpisodes += getChannelVideosByDateRange(channelId, oldestSavedEpisode.PublishedDate, time.Unix(0, 0))
 		} else {
-			getChannelVideosByDateRange(channelId, time.Unix(0, 0), time.Unix(0, 0))
+			newEpisodes += getChannelVideosByDateRange(channelId, time.Unix(0, 0), time.Unix(0, 0))
 		}
 	}
+	return newEpisodes
 }
 
-func getChannelVideosByDateRange(channelID string, beforeDateParam time.Time, afterDateParam time.Time) {
+func getChannelVideosByDateRange(channelID string, beforeDateParam time.Time, afterDateParam time.Time) int {
 
 	savedEpisodeIds, err := database.GetAllPodcastEpisodeIds(channelID)
 	if err != nil {
 		log.Error(err)
-		return
+		return 0
 	}
 
+	newEpisodes := 0
+
 	nextPageToken := ""
 	for {
 		var videoIdsNotSaved []string
@@ -111,12 +113,12 @@ func getChannelVideosByDateRange(channelID string, beforeDateParam time.Time, af
 		searchCallResponse, err := searchCall.Do()
 		if err != nil {
 			log.Error(err)
-			return
+			return newEpisodes
 		}
 
 		videoIdsNotSaved = append(videoIdsNotSaved, getValidVideosFromChannelResponse(searchCallResponse, savedEpisodeIds)...)
 		if len(videoIdsNotSaved) > 0 {
-			youtube.GetVideosAndValidate(videoIdsNotSaved, enum.CHANNEL, channelID)
+			newEpisodes += youtube.GetVideosAndValidate(videoIdsNotSaved, enum.CHANNEL, channelID)
 		}
 
 		nextPageToken = searchCallResponse.NextPageToken
@@ -124,6 +126,7 @@ func getChannelVideosByDateRange(channelID string, beforeDateParam time.Time, af
 			break
 		}
 	}
+	return newEpisodes
 }
 
 func getValidVideosFromChannelResponse(channelVideoResponse *ytApi.SearchListResponse, savedEpisodeIds []string) []string {
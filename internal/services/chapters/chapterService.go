@@ -0,0 +1,249 @@
+package chapters
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/labstack/gommon/log"
+)
+
+// https://github.com/Podcastindex-org/podcast-namespace/blob/main/docs/examples/chapters/jsonChapters.md
+const chaptersVersion = "1.2.0"
+
+// Chapters is a Podcasting 2.0 JSON chapters document.
+type Chapters struct {
+	Version  string    `json:"version"`
+	Chapters []Chapter `json:"chapters"`
+}
+
+// Chapter is a single entry of a JSON chapters document. StartTime is in
+// seconds.
+type Chapter struct {
+	StartTime float64 `json:"startTime"`
+	Title     string  `json:"title"`
+}
+
+var timestampRegex = regexp.MustCompile(`(?:(\d{1,2}):)?(\d{1,2}):(\d{2})`)
+
+// BuildChapters reads the chapter timestamps from the episode description
+// and shifts them to line up with the audio served by /media, i.e. with the
+// SponsorBlock segments removed and the podcast's audio processing applied.
+// With the key of a variant they line up with that variant instead, which
+// isn't processed; a variant marking its segments keeps the timestamps and
+// gets a chapter for every segment. It returns nil when there are no
+// chapters.
+func BuildChapters(youtubeVideoId string, variant string) (*Chapters, error) {
+	episode, err := database.GetEpisodeByVideoId(youtubeVideoId)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, mark := sponsorblock.ParseVariantKey(variant)
+	if mark {
+		return newChapters(MarkedChapters(episode, categories)), nil
+	}
+
+	descriptionChapters := ParseDescriptionChapters(episode.EpisodeDescription)
+	if len(descriptionChapters) == 0 {
+		return nil, nil
+	}
+
+	log.Debug("[CHAPTERS] Shifting chapters for removed segments...")
+	if variant != "" {
+		segments := sponsorblock.GetSponsorSegmentsFor(youtubeVideoId, categories)
+		return newChapters(ShiftChapters(descriptionChapters, segments)), nil
+	}
+	segments := sponsorblock.GetSponsorSegments(youtubeVideoId)
+	shifted := ShiftChapters(descriptionChapters, segments)
+	// Line up with silence trimming and tempo changes as well.
+	mapTime := database.ProcessedTimeMapper(youtubeVideoId)
+	for i := range shifted {
+		shifted[i].StartTime = mapTime(shifted[i].StartTime)
+	}
+	return newChapters(shifted), nil
+}
+
+// MarkedChapters returns the chapters of the uncut audio of an episode with
+// the segments of the given categories marked.
+func MarkedChapters(episode *models.PodcastEpisode, categories []string) []Chapter {
+	log.Debug("[CHAPTERS] Marking segments with chapters...")
+	segments := sponsorblock.GetSponsorSegmentsFor(episode.YoutubeVideoId, categories)
+	return MarkSegments(ParseDescriptionChapters(episode.EpisodeDescription), segments, episode.Duration.Seconds())
+}
+
+func newChapters(chapters []Chapter) *Chapters {
+	if len(chapters) == 0 {
+		return nil
+	}
+	return &Chapters{
+		Version:  chaptersVersion,
+		Chapters: chapters,
+	}
+}
+
+// HasChapters reports whether the description holds a usable chapter list.
+func HasChapters(description string) bool {
+	return len(ParseDescriptionChapters(description)) > 0
+}
+
+// ParseDescriptionChapters extracts chapters from a YouTube description.
+//
+// Following YouTube's own rules, a line counts as a chapter when it starts or
+// ends with a timestamp, the timestamps must be in ascending order and there
+// must be at least two of them. Lines that would go back in time are ignored.
+func ParseDescriptionChapters(description string) []Chapter {
+	var chapters []Chapter
+	for _, line := range strings.Split(description, "\n") {
+		loc := timestampRegex.FindStringSubmatchIndex(line)
+		if loc == nil {
+			continue
+		}
+		startTime := parseTimestamp(line, loc)
+		if len(chapters) > 0 && startTime <= chapters[len(chapters)-1].StartTime {
+			continue
+		}
+
+		// The timestamp has to lead or trail the title, otherwise it is just
+		// a time mentioned in a sentence.
+		before, after := cleanTitle(line[:loc[0]]), cleanTitle(line[loc[1]:])
+		title := after
+		if before != "" {
+			if after != "" {
+				continue
+			}
+			title = before
+		}
+		if title == "" {
+			continue
+		}
+		chapters = append(chapters, Chapter{StartTime: startTime, Title: title})
+	}
+
+	if len(chapters) < 2 {
+		return nil
+	}
+	return chapters
+}
+
+// episodeChapterTitle titles the parts of an episode without chapters of its
+// own between marked segments.
+const episodeChapterTitle = "Episode"
+
+// MarkSegments adds a chapter titled after the category of every segment to
+// the chapters of the uncut audio, and one where the segment ends resuming
+// the chapter it interrupted. Chapters starting inside a segment resume at
+// its end. Overlapping segments are marked one after the other.
+func MarkSegments(chapters []Chapter, segments []sponsorblock.SponsorBlockResponse, duration float64) []Chapter {
+	sorted := make([]sponsorblock.SponsorBlockResponse, 0, len(segments))
+	for _, segment := range segments {
+		if len(segment.Segment) >= 2 && segment.Segment[1] > segment.Segment[0] {
+			sorted = append(sorted, segment)
+		}
+	}
+	if len(sorted) == 0 {
+		return chapters
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Segment[0] < sorted[j].Segment[0] })
+
+	titleAt := func(timestamp float64) string {
+		title := episodeChapterTitle
+		for _, chapter := range chapters {
+			if chapter.StartTime <= timestamp {
+				title = chapter.Title
+			}
+		}
+		return title
+	}
+
+	// Marks win over chapters starting at the same time, which win over
+	// resumed chapters.
+	type entry struct {
+		chapter  Chapter
+		priority int
+	}
+	entries := []entry{{Chapter{StartTime: 0, Title: titleAt(0)}, 2}}
+	var marked [][2]float64
+	prevEnd := 0.0
+	for _, segment := range sorted {
+		start, end := math.Max(segment.Segment[0], prevEnd), segment.Segment[1]
+		if end <= start {
+			continue
+		}
+		prevEnd = end
+		marked = append(marked, [2]float64{start, end})
+		entries = append(entries, entry{Chapter{StartTime: start, Title: sponsorblock.CategoryLabel(segment.Category)}, 0})
+		// A segment running until the end has nothing to resume.
+		if duration <= 0 || end < duration-1 {
+			entries = append(entries, entry{Chapter{StartTime: end, Title: titleAt(end)}, 2})
+		}
+	}
+	for _, chapter := range chapters {
+		inside := false
+		for _, r := range marked {
+			if chapter.StartTime >= r[0] && chapter.StartTime < r[1] {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			entries = append(entries, entry{chapter, 1})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].chapter.StartTime != entries[j].chapter.StartTime {
+			return entries[i].chapter.StartTime < entries[j].chapter.StartTime
+		}
+		return entries[i].priority < entries[j].priority
+	})
+	result := make([]Chapter, 0, len(entries))
+	for _, e := range entries {
+		last := len(result) - 1
+		if last >= 0 && e.chapter.StartTime == result[last].StartTime {
+			continue
+		}
+		// A resumed chapter right after the same chapter adds nothing.
+		if last >= 0 && e.priority == 2 && e.chapter.Title == result[last].Title {
+			continue
+		}
+		result = append(result, e.chapter)
+	}
+	return result
+}
+
+// ShiftChapters moves each chapter back by the segments removed before it.
+// Chapters that end up starting at the same time as the next one, because
+// they were cut out entirely, are dropped.
+func ShiftChapters(chapters []Chapter, segments []sponsorblock.SponsorBlockResponse) []Chapter {
+	shifted := make([]Chapter, 0, len(chapters))
+	for _, chapter := range chapters {
+		chapter.StartTime = sponsorblock.MapToCutTime(chapter.StartTime, segments)
+		if last := len(shifted) - 1; last >= 0 && chapter.StartTime <= shifted[last].StartTime {
+			shifted[last] = chapter
+			continue
+		}
+		shifted = append(shifted, chapter)
+	}
+	return shifted
+}
+
+func parseTimestamp(line string, loc []int) float64 {
+	seconds := 0
+	if loc[2] >= 0 {
+		hours, _ := strconv.Atoi(line[loc[2]:loc[3]])
+		seconds += hours * 3600
+	}
+	minutes, _ := strconv.Atoi(line[loc[4]:loc[5]])
+	secs, _ := strconv.Atoi(line[loc[6]:loc[7]])
+	return float64(seconds + minutes*60 + secs)
+}
+
+func cleanTitle(title string) string {
+	return strings.Trim(title, " \t\r-–—:|•·()[]")
+}
@@ -0,0 +1,111 @@
+package chapters
+
+import (
+	"testing"
+
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+)
+
+func TestParseDescriptionChapters(t *testing.T) {
+	description := `Thanks for watching!
+Check out our sponsor at 1:00 in the video.
+
+0:00 Intro
+[02:30] - Guest introduction
+Main topic – 15:05
+1:02:03 Wrap up
+00:10 not a chapter, goes back in time`
+
+	got := ParseDescriptionChapters(description)
+	want := []Chapter{
+		{StartTime: 0, Title: "Intro"},
+		{StartTime: 150, Title: "Guest introduction"},
+		{StartTime: 905, Title: "Main topic"},
+		{StartTime: 3723, Title: "Wrap up"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d chapters, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("chapter %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseDescriptionChapters_NeedsTwoTimestamps(t *testing.T) {
+	if got := ParseDescriptionChapters("Full episode\n0:00 Start"); got != nil {
+		t.Fatalf("expected no chapters, got %+v", got)
+	}
+}
+
+func TestShiftChapters(t *testing.T) {
+	chapters := []Chapter{
+		{StartTime: 0, Title: "Intro"},
+		{StartTime: 100, Title: "Sponsor read"},
+		{StartTime: 160, Title: "Topic"},
+		{StartTime: 400, Title: "Outro"},
+	}
+	segments := []sponsorblock.SponsorBlockResponse{
+		{Segment: []float64{300, 320}},
+		{Segment: []float64{90, 150}},
+		{Segment: []float64{140, 160}},
+	}
+
+	got := ShiftChapters(chapters, segments)
+	want := []Chapter{
+		{StartTime: 0, Title: "Intro"},
+		{StartTime: 90, Title: "Topic"},
+		{StartTime: 310, Title: "Outro"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d chapters, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("chapter %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMarkSegments(t *testing.T) {
+	chapters := []Chapter{
+		{StartTime: 0, Title: "Intro"},
+		{StartTime: 100, Title: "Main topic"},
+		{StartTime: 500, Title: "Wrap up"},
+	}
+	segments := []sponsorblock.SponsorBlockResponse{
+		{Segment: []float64{90, 130}, Category: "sponsor"},
+		{Segment: []float64{120, 140}, Category: "selfpromo"},
+		{Segment: []float64{580, 600}, Category: "outro"},
+	}
+
+	got := MarkSegments(chapters, segments, 600)
+	want := []Chapter{
+		{StartTime: 0, Title: "Intro"},
+		{StartTime: 90, Title: "Sponsor"},
+		{StartTime: 130, Title: "Self-promo"},
+		{StartTime: 140, Title: "Main topic"},
+		{StartTime: 500, Title: "Wrap up"},
+		{StartTime: 580, Title: "Outro"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d chapters, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("chapter %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMarkSegments_WithoutChapters(t *testing.T) {
+	segments := []sponsorblock.SponsorBlockResponse{{Segment: []float64{0, 30}, Category: "sponsor"}}
+	got := MarkSegments(nil, segments, 600)
+	if len(got) != 2 || got[0] != (Chapter{StartTime: 0, Title: "Sponsor"}) || got[1] != (Chapter{StartTime: 30, Title: "Episode"}) {
+		t.Fatalf("unexpected chapters %+v", got)
+	}
+	if got := MarkSegments(nil, nil, 600); got != nil {
+		t.Fatalf("expected no chapters without segments, got %+v", got)
+	}
+}
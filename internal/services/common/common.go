@@ -1,6 +1,11 @@
 package common
 
 import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -45,6 +50,32 @@ func ParseDuration(durationStr string) (time.Duration, error) {
 	return time.ParseDuration(durationStr)
 }
 
+var byteSizeRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kmgt]i?)?b?$`)
+
+// ParseByteSize reads a size such as "500MB", "20GB" or "1.5TiB" in bytes.
+// KB, MB, GB and TB are decimal units, KiB, MiB, GiB and TiB binary ones and a
+// plain number is in bytes.
+func ParseByteSize(size string) (int64, error) {
+	match := byteSizeRegex.FindStringSubmatch(strings.ToLower(strings.TrimSpace(size)))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := match[2]
+	base := 1000.0
+	if strings.HasSuffix(unit, "i") {
+		base = 1024
+	}
+	exponent := 0
+	if unit != "" {
+		exponent = strings.IndexByte("kmgt", unit[0]) + 1
+	}
+	return int64(value * math.Pow(base, float64(exponent))), nil
+}
+
 func IsValidFilename(filename string) bool {
 	for _, c := range filename {
 		if !unicode.IsLetter(c) && !unicode.IsNumber(c) && c != '.' && c != '_' && c != '-' {
@@ -54,6 +85,18 @@ func IsValidFilename(filename string) bool {
 	return true
 }
 
+// IsPartialFile reports whether a file in a media directory is still being
+// written, e.g. a yt-dlp .part file or an intermediate file of a post
+// processor, rather than a finished episode.
+func IsPartialFile(filename string) bool {
+	ext := filepath.Ext(filename)
+	return ext == ".part" || ext == ".ytdl" || strings.Contains(filename, ".temp.") || partialFormatRegex.MatchString(filename)
+}
+
+// partialFormatRegex matches the per-format files yt-dlp downloads before
+// merging them, e.g. abc.f137.mp4.
+var partialFormatRegex = regexp.MustCompile(`\.f\d+\.[^.]+$`)
+
 func IsValidParam(param string) bool {
 	if strings.Contains(param, "/") || strings.Contains(param, "\\") || strings.Contains(param, "..") {
 		return false
@@ -69,3 +112,52 @@ func IsValidID(id string) bool {
 	}
 	return true
 }
+
+// AudioCodecFromExtension guesses the audio codec of a file from its
+// extension, for when the file cannot be probed.
+func AudioCodecFromExtension(ext string) string {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "m4a", "mp4", "aac":
+		return "aac"
+	case "opus", "webm":
+		return "opus"
+	case "ogg", "oga":
+		return "vorbis"
+	case "mp3":
+		return "mp3"
+	case "flac":
+		return "flac"
+	}
+	return ""
+}
+
+// MediaTypeFromExtension returns the MIME type of an episode file from its
+// extension, with or without the leading dot. Unknown extensions default to
+// audio/mp4, the format downloads are requested in.
+func MediaTypeFromExtension(ext string) string {
+	// https://help.apple.com/itc/podcasts_connect/#/itcb54353390
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "mp3":
+		return "audio/mpeg"
+	case "m4v":
+		return "video/x-m4v"
+	case "mp4":
+		return "video/mp4"
+	case "mov":
+		return "video/quicktime"
+	case "opus", "ogg", "oga":
+		return "audio/ogg"
+	case "webm":
+		return "audio/webm"
+	case "aac":
+		return "audio/aac"
+	case "flac":
+		return "audio/flac"
+	}
+	return "audio/mp4"
+}
+
+// TrimExtension returns the file name without its extension.
+func TrimExtension(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
@@ -0,0 +1,455 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"ikoyhn/podcast-sponsorblock/internal/services/ntfy"
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/labstack/gommon/log"
+)
+
+const (
+	// retryBackoff is the wait before the first retry of a failed download,
+	// doubled for every further attempt.
+	retryBackoff = 30 * time.Second
+	// maxRetryBackoff caps the wait between two attempts.
+	maxRetryBackoff = 30 * time.Minute
+	// queuePollInterval is how often idle workers look for jobs whose retry
+	// has become due.
+	queuePollInterval = 5 * time.Second
+)
+
+// downloadQueue runs the download jobs stored in the database on a fixed
+// number of workers. Waiters are only kept in memory while their job is
+// pending, so the map never grows past the number of queued jobs.
+//
+// Workers stop taking jobs once ctx is done. Downloads run with downloadCtx,
+// which a shutdown cancels separately, so running downloads can be given time
+// to finish; running tracks them.
+type downloadQueue struct {
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
+	wake    chan struct{}
+
+	ctx             context.Context
+	downloadCtx     context.Context
+	cancelDownloads context.CancelFunc
+	running         sync.WaitGroup
+}
+
+var queue = newDownloadQueue(context.Background())
+
+func newDownloadQueue(ctx context.Context) *downloadQueue {
+	downloadCtx, cancelDownloads := context.WithCancel(context.Background())
+	return &downloadQueue{
+		waiters:         map[string][]chan struct{}{},
+		wake:            make(chan struct{}, 1),
+		ctx:             ctx,
+		downloadCtx:     downloadCtx,
+		cancelDownloads: cancelDownloads,
+	}
+}
+
+// StartDownloadQueue requeues the jobs interrupted by the last shutdown,
+// deletes the partial files they left behind and starts the download
+// workers. The workers stop taking jobs once ctx is done.
+func StartDownloadQueue(ctx context.Context) {
+	if err := database.RequeueRunningDownloadJobs(); err != nil {
+		log.Error(err)
+	}
+	removePartialFiles()
+	queue = newDownloadQueue(ctx)
+
+	workers := config.AppConfig.Ytdlp.DownloadConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	log.Infof("[DOWNLOAD QUEUE] Starting %d download workers...", workers)
+	for i := 0; i < workers; i++ {
+		go queue.work()
+	}
+	queue.notify()
+}
+
+// StopDownloadQueue stops the downloads for a shutdown. Running downloads are
+// cancelled right away, or with wait given until the timeout to finish.
+// Interrupted jobs stay queued for the next start. Everyone still waiting on
+// a download is released and partial files are deleted.
+func StopDownloadQueue(wait bool, timeout time.Duration) {
+	if !wait {
+		queue.cancelDownloads()
+	}
+	if !waitFor(&queue.running, timeout) {
+		log.Warn("[DOWNLOAD QUEUE] Downloads didn't finish in time, cancelling them...")
+	}
+	queue.cancelDownloads()
+	// Killed processes exit right away, this only covers the cleanup.
+	waitFor(&queue.running, 10*time.Second)
+
+	queue.releaseAll()
+	removePartialFiles()
+}
+
+// waitFor waits for wg until the timeout, returning false when it ran out.
+// A timeout of 0 waits without limit.
+func waitFor(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if timeout <= 0 {
+		<-done
+		return true
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// downloadContext returns the context a download or ffmpeg pass runs with:
+// cancelled on shutdown and bounded by the configured download timeout.
+func downloadContext() (context.Context, context.CancelFunc) {
+	if timeout := config.DownloadTimeout(); timeout > 0 {
+		return context.WithTimeout(queue.downloadCtx, timeout)
+	}
+	return context.WithCancel(queue.downloadCtx)
+}
+
+// interrupted reports whether downloads were cancelled by a shutdown.
+func (q *downloadQueue) interrupted() bool {
+	return q.downloadCtx.Err() != nil
+}
+
+// removePartialFiles deletes the partial downloads and ffmpeg outputs left
+// behind by interrupted jobs, so they are never mistaken for episodes.
+func removePartialFiles() {
+	dirs := append(config.EpisodeDirs(), config.AppConfig.Setup.SourceDir)
+	// Partial files are always local, as are the variants being downloaded.
+	for _, media := range []enum.MediaType{enum.AUDIO, enum.VIDEO} {
+		variantDirs, _ := filepath.Glob(config.VariantDir(media, "*"))
+		dirs = append(dirs, variantDirs...)
+	}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, replaceDir)); err != nil {
+			log.Warn(err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !common.IsPartialFile(entry.Name()) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				log.Warn(err)
+				continue
+			}
+			log.Infof("[DOWNLOAD QUEUE] Deleted partial file %s", entry.Name())
+		}
+	}
+}
+
+// GetDownloadJobs returns the downloads that are queued, running or failed.
+func GetDownloadJobs() ([]models.DownloadJob, error) {
+	return database.GetDownloadJobs()
+}
+
+func (q *downloadQueue) enqueue(youtubeVideoId string, media enum.MediaType, profile string, categories string) <-chan struct{} {
+	done := make(chan struct{})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := database.EnqueueDownloadJob(youtubeVideoId, media, profile, categories); err != nil {
+		log.Errorf("[DOWNLOAD QUEUE] Unable to queue %s: %v", youtubeVideoId, err)
+		close(done)
+		return done
+	}
+	key := jobKey(youtubeVideoId, media, profile, categories)
+	q.waiters[key] = append(q.waiters[key], done)
+	q.notify()
+	return done
+}
+
+// notify wakes an idle worker, if any.
+func (q *downloadQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// release closes the channels of everyone waiting on the job.
+func (q *downloadQueue) release(youtubeVideoId string, media enum.MediaType, profile string, categories string) {
+	key := jobKey(youtubeVideoId, media, profile, categories)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, done := range q.waiters[key] {
+		close(done)
+	}
+	delete(q.waiters, key)
+}
+
+// releaseAll closes the channels of everyone waiting on any job.
+func (q *downloadQueue) releaseAll() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for key, waiters := range q.waiters {
+		for _, done := range waiters {
+			close(done)
+		}
+		delete(q.waiters, key)
+	}
+}
+
+func (q *downloadQueue) work() {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for q.ctx.Err() == nil {
+		job, err := database.ClaimNextDownloadJob()
+		if err != nil {
+			log.Error(err)
+		}
+		if job == nil {
+			select {
+			case <-q.wake:
+			case <-ticker.C:
+			case <-q.ctx.Done():
+			}
+			continue
+		}
+		// More jobs may be waiting, let another idle worker look.
+		q.notify()
+		q.run(job)
+	}
+}
+
+func (q *downloadQueue) run(job *models.DownloadJob) {
+	q.running.Add(1)
+	defer q.running.Done()
+
+	media := enum.MediaType(job.Media)
+	if job.Profile != "" && !database.FileExistsWithId(config.AppConfig.Setup.AudioDir, job.YoutubeVideoId) {
+		q.waitForSource(job)
+		return
+	}
+	job.Attempts++
+
+	ctx, cancel := downloadContext()
+	defer cancel()
+
+	var err error
+	downloadedAudio := false
+	switch {
+	case job.Replace:
+		log.Infof("[DOWNLOAD QUEUE] Cutting %s %s again (attempt %d)...", job.Media, job.YoutubeVideoId, job.Attempts)
+		err = replaceFile(ctx, job)
+	case job.Profile != "":
+		if !database.FileExistsWithId(config.ProfileDir(job.Profile), job.YoutubeVideoId) {
+			log.Infof("[DOWNLOAD QUEUE] Transcoding %s with profile %s (attempt %d)...", job.YoutubeVideoId, job.Profile, job.Attempts)
+			err = transcodeProfile(ctx, job.YoutubeVideoId, job.Profile)
+		}
+	case job.Categories != "":
+		if !database.FileExistsWithId(jobDir(job), job.YoutubeVideoId) {
+			log.Infof("[DOWNLOAD QUEUE] Downloading %s %s as %s (attempt %d)...", job.Media, job.YoutubeVideoId, job.Categories, job.Attempts)
+			categories, mark := sponsorblock.ParseVariantKey(job.Categories)
+			if mark {
+				err = downloadMarked(ctx, job.YoutubeVideoId, media, jobDir(job), categories)
+			} else {
+				err = downloadMedia(ctx, job.YoutubeVideoId, media, jobDir(job), categories)
+			}
+		}
+	case !database.FileExistsWithId(config.MediaDir(media), job.YoutubeVideoId):
+		log.Infof("[DOWNLOAD QUEUE] Downloading %s %s (attempt %d)...", job.Media, job.YoutubeVideoId, job.Attempts)
+		err = downloadMedia(ctx, job.YoutubeVideoId, media, config.MediaDir(media), sponsorblock.Categories(job.YoutubeVideoId))
+		if err == nil && media == enum.AUDIO {
+			processAudio(ctx, job.YoutubeVideoId)
+			downloadedAudio = true
+		}
+	}
+	if err == nil {
+		// Also covers a file left local by an upload that failed before.
+		err = storeFile(jobDir(job), job.YoutubeVideoId)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s: %w", config.DownloadTimeout(), err)
+	}
+
+	switch {
+	case err != nil && q.interrupted():
+		// Not the download's fault, run it again after the restart.
+		log.Infof("[DOWNLOAD QUEUE] Download of %s interrupted by shutdown", job.YoutubeVideoId)
+		job.Attempts--
+		job.State = string(enum.QUEUED)
+		job.LastError = ""
+		job.NextAttemptAt = time.Now()
+	case err == nil:
+		job.State = string(enum.DONE)
+		job.LastError = ""
+	case job.Attempts > config.AppConfig.Ytdlp.DownloadRetries:
+		log.Errorf("[DOWNLOAD QUEUE] Giving up on %s after %d attempts: %v", job.YoutubeVideoId, job.Attempts, err)
+		ntfy.SendNotification(fmt.Sprintf("Download of %s failed after %d attempts", job.YoutubeVideoId, job.Attempts), "Clean Cast - Error")
+		job.State = string(enum.FAILED)
+		job.LastError = err.Error()
+	default:
+		job.State = string(enum.QUEUED)
+		job.LastError = err.Error()
+		job.NextAttemptAt = time.Now().Add(retryDelay(job.Attempts))
+		log.Warnf("[DOWNLOAD QUEUE] Download of %s failed, retrying at %s: %v", job.YoutubeVideoId, job.NextAttemptAt.Format(time.RFC3339), err)
+	}
+
+	if updateErr := database.UpdateDownloadJob(job); updateErr != nil {
+		log.Error(updateErr)
+	}
+	q.release(job.YoutubeVideoId, media, job.Profile, job.Categories)
+	if err == nil {
+		database.EnforceCacheBudget()
+	}
+	// Feeds only link the transcripts of captions that were fetched.
+	if err == nil && downloadedAudio {
+		fetchSubtitles(job.YoutubeVideoId)
+	}
+}
+
+// waitForSource queues the download of the audio a transcode job needs and
+// puts the transcode back in the queue, without counting it as an attempt.
+// The transcode fails once the download has failed for good.
+func (q *downloadQueue) waitForSource(job *models.DownloadJob) {
+	source := database.GetDownloadJob(job.YoutubeVideoId, enum.AUDIO, "")
+	switch {
+	case source != nil && source.State == string(enum.FAILED):
+		job.State = string(enum.FAILED)
+		job.LastError = "audio download failed: " + source.LastError
+	case source == nil || source.State == string(enum.DONE):
+		if _, err := database.EnqueueDownloadJob(job.YoutubeVideoId, enum.AUDIO, "", ""); err != nil {
+			log.Error(err)
+		}
+		fallthrough
+	default:
+		job.State = string(enum.QUEUED)
+		job.NextAttemptAt = time.Now().Add(queuePollInterval)
+	}
+	if err := database.UpdateDownloadJob(job); err != nil {
+		log.Error(err)
+	}
+	if job.State == string(enum.FAILED) {
+		q.release(job.YoutubeVideoId, enum.AUDIO, job.Profile, job.Categories)
+	}
+	q.notify()
+}
+
+// retryDelay returns the exponential backoff before the next attempt, after
+// the given number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBackoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	return delay
+}
+
+// replaceDir is the directory, below the one of the file a job replaces, the
+// new file is downloaded into.
+const replaceDir = "replace"
+
+// replaceFile downloads the file of a job again next to the cached one and
+// moves it over the cached one once done. Replaced audio is processed again
+// and the audio profiles cached for it are transcoded again. Marked files
+// only get their chapters written again.
+func replaceFile(ctx context.Context, job *models.DownloadJob) error {
+	media := enum.MediaType(job.Media)
+	dir := jobDir(job)
+	staging := filepath.Join(dir, replaceDir)
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	var err error
+	categories, mark := sponsorblock.ParseVariantKey(job.Categories)
+	switch {
+	case mark:
+		// A marked file has nothing cut, only its chapters are outdated.
+		if cached := database.FindFileWithId(dir, job.YoutubeVideoId); cached != "" {
+			return remarkFile(ctx, job.YoutubeVideoId, cached, categories)
+		}
+		err = downloadMarked(ctx, job.YoutubeVideoId, media, staging, categories)
+	case job.Categories != "":
+		err = downloadMedia(ctx, job.YoutubeVideoId, media, staging, categories)
+	default:
+		err = downloadMedia(ctx, job.YoutubeVideoId, media, staging, sponsorblock.Categories(job.YoutubeVideoId))
+	}
+	if err != nil {
+		return err
+	}
+	staged := database.FindFileWithId(staging, job.YoutubeVideoId)
+	if staged == "" {
+		return fmt.Errorf("no file downloaded for %s", job.YoutubeVideoId)
+	}
+
+	target := filepath.Join(dir, filepath.Base(staged))
+	if cached := database.FindFileWithId(dir, job.YoutubeVideoId); cached != "" && cached != target {
+		if err := storage.Remove(cached); err != nil && !os.IsNotExist(err) {
+			log.Warn(err)
+		}
+	}
+	if err := os.Rename(staged, target); err != nil {
+		return err
+	}
+	if media != enum.AUDIO || job.Categories != "" {
+		return nil
+	}
+
+	processAudio(ctx, job.YoutubeVideoId)
+	for profile := range config.AppConfig.AudioProfiles {
+		profileDir := config.ProfileDir(profile)
+		if cached := database.FindFileWithId(profileDir, job.YoutubeVideoId); cached != "" {
+			if err := storage.Remove(cached); err != nil && !os.IsNotExist(err) {
+				log.Warn(err)
+			}
+			if _, err := database.EnqueueDownloadJob(job.YoutubeVideoId, enum.AUDIO, profile, ""); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+	return nil
+}
+
+// jobDir returns the directory the file a job produces is cached in.
+func jobDir(job *models.DownloadJob) string {
+	if job.Profile != "" {
+		return config.ProfileDir(job.Profile)
+	}
+	if job.Categories != "" {
+		return config.VariantDir(enum.MediaType(job.Media), job.Categories)
+	}
+	return config.MediaDir(enum.MediaType(job.Media))
+}
+
+func jobKey(youtubeVideoId string, media enum.MediaType, profile string, categories string) string {
+	return string(media) + ":" + profile + ":" + categories + ":" + youtubeVideoId
+}
@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemovePartialFiles(t *testing.T) {
+	dir := t.TempDir()
+	config.AppConfig = &config.Config{}
+	config.AppConfig.Setup.AudioDir = filepath.Join(dir, "audio")
+	config.AppConfig.Setup.SourceDir = filepath.Join(dir, "source")
+	for _, d := range []string{config.AppConfig.Setup.AudioDir, config.AppConfig.Setup.SourceDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]bool{
+		"audio/abc.m4a":        true,
+		"audio/def.m4a.part":   false,
+		"audio/ghi.temp.m4a":   false,
+		"source/abc.f140.m4a":  false,
+		"source/abc.opus":      true,
+		"source/abc.opus.ytdl": false,
+	}
+	for name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removePartialFiles()
+
+	for name, kept := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if kept != (err == nil) {
+			t.Errorf("%s: expected kept=%v, got err %v", name, kept, err)
+		}
+	}
+}
+
+func TestStopDownloadQueueReleasesWaiters(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	q := newDownloadQueue(t.Context())
+	done := make(chan struct{})
+	q.waiters[jobKey("abc", "audio", "", "")] = []chan struct{}{done}
+
+	queue, q = q, queue
+	defer func() { queue = q }()
+	StopDownloadQueue(false, time.Second)
+
+	select {
+	case <-done:
+	default:
+		t.Error("expected waiters to be released")
+	}
+	if !queue.interrupted() {
+		t.Error("expected downloads to be cancelled")
+	}
+}
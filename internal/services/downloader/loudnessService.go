@@ -0,0 +1,239 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/labstack/gommon/log"
+)
+
+// loudnessRange is the loudness range (LRA) target of the loudnorm filter.
+// It only matters when the filter has to fall back to dynamic mode.
+const loudnessRange = 11.0
+
+// loudnormStats is the JSON loudnorm prints after measuring a file. ffmpeg
+// writes every value as a string.
+type loudnormStats struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// NormalizeLoudness normalizes the downloaded audio of a video to the
+// configured EBU R128 target with a two-pass loudnorm: the first pass
+// measures the file, the second applies a linear gain based on the
+// measurement. The measurement is stored, and a file that was already
+// normalized to the current target is left alone.
+func NormalizeLoudness(ctx context.Context, youtubeVideoId string) error {
+	if !config.AppConfig.Loudness.Enabled {
+		return nil
+	}
+	filePath := database.FindFileWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId)
+	if filePath == "" {
+		return nil
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	targetLufs := config.AppConfig.Loudness.TargetLufs
+	truePeak := config.AppConfig.Loudness.TruePeak
+	if loudnessUpToDate(database.GetLoudnessMeasurement(youtubeVideoId), info.Size(), targetLufs, truePeak) {
+		return nil
+	}
+
+	log.Infof("[LOUDNESS] Measuring %s...", youtubeVideoId)
+	out, err := exec.CommandContext(ctx, ffmpegBinary(), "-hide_banner", "-nostats", "-i", filePath, "-vn",
+		"-af", loudnormFilter(targetLufs, truePeak, nil), "-f", "null", "-").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	measurement, err := parseLoudnormStats(out)
+	if err != nil {
+		return err
+	}
+	measurement.YoutubeVideoId = youtubeVideoId
+	measurement.TargetLufs = targetLufs
+	measurement.TruePeak = truePeak
+
+	log.Infof("[LOUDNESS] Normalizing %s from %.1f to %.1f LUFS...", youtubeVideoId, measurement.InputI, targetLufs)
+	ext := strings.TrimPrefix(filepath.Ext(filePath), ".")
+	partPath := filePath + ".part"
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", filePath, "-vn", "-map_metadata", "0",
+		"-af", loudnormFilter(targetLufs, truePeak, measurement), "-ar", "48000"}
+	args = append(args, encoderArgs(ext)...)
+	if out, err := exec.CommandContext(ctx, ffmpegBinary(), append(args, partPath)...).CombinedOutput(); err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(filePath); err == nil {
+		measurement.FileSize = info.Size()
+	}
+	if err := database.SaveLoudnessMeasurement(measurement); err != nil {
+		return err
+	}
+	// Transcodes were made from the audio before it was normalized.
+	for profile := range config.AppConfig.AudioProfiles {
+		if variant := database.FindFileWithId(config.ProfileDir(profile), youtubeVideoId); variant != "" {
+			storage.Remove(variant)
+		}
+	}
+	return nil
+}
+
+// NormalizeCachedEpisodes normalizes, in the background, the downloaded
+// episodes that haven't been normalized to the current target yet, e.g.
+// after loudness normalization was enabled or the target was changed.
+func NormalizeCachedEpisodes() {
+	if !config.AppConfig.Loudness.Enabled {
+		return
+	}
+	queue.running.Add(1)
+	go func() {
+		defer queue.running.Done()
+
+		audioDir := config.AppConfig.Setup.AudioDir
+		files, err := storage.List(audioDir, "")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		for _, file := range files {
+			youtubeVideoId := common.TrimExtension(file.Name)
+			if !common.IsValidFilename(file.Name) || loudnessUpToDate(database.GetLoudnessMeasurement(youtubeVideoId), file.Size,
+				config.AppConfig.Loudness.TargetLufs, config.AppConfig.Loudness.TruePeak) {
+				continue
+			}
+			if queue.interrupted() {
+				return
+			}
+			if err := normalizeStoredEpisode(filepath.Join(audioDir, file.Name), youtubeVideoId); err != nil {
+				log.Warnf("[LOUDNESS] Unable to normalize %s: %v", file.Name, err)
+			}
+		}
+	}()
+}
+
+// normalizeStoredEpisode normalizes an episode that may only be kept by the
+// storage backend, storing the normalized file again.
+func normalizeStoredEpisode(filePath string, youtubeVideoId string) error {
+	release, err := storage.Fetch(filePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := NormalizeLoudness(queue.downloadCtx, youtubeVideoId); err != nil {
+		return err
+	}
+	return storage.Store(filePath)
+}
+
+// loudnessUpToDate reports whether the file of the given size was already
+// normalized to the target.
+func loudnessUpToDate(measurement *models.LoudnessMeasurement, fileSize int64, targetLufs float64, truePeak float64) bool {
+	return measurement != nil &&
+		measurement.FileSize == fileSize &&
+		measurement.TargetLufs == targetLufs &&
+		measurement.TruePeak == truePeak
+}
+
+// loudnormFilter builds the loudnorm filter. Without a measurement it is the
+// measuring first pass, with one it is the second pass applying a linear gain.
+func loudnormFilter(targetLufs float64, truePeak float64, measured *models.LoudnessMeasurement) string {
+	filter := fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s", formatLoudness(targetLufs), formatLoudness(truePeak), formatLoudness(loudnessRange))
+	if measured == nil {
+		return filter + ":print_format=json"
+	}
+	return filter + fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true:print_format=summary",
+		formatLoudness(measured.InputI), formatLoudness(measured.InputTP), formatLoudness(measured.InputLRA),
+		formatLoudness(measured.InputThresh), formatLoudness(measured.TargetOffset))
+}
+
+// parseLoudnormStats reads the measurement loudnorm prints as the last JSON
+// object of the ffmpeg output.
+func parseLoudnormStats(output []byte) (*models.LoudnessMeasurement, error) {
+	text := string(output)
+	start := strings.LastIndex(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no loudnorm measurement in ffmpeg output")
+	}
+	var stats loudnormStats
+	if err := json.Unmarshal([]byte(text[start:end+1]), &stats); err != nil {
+		return nil, err
+	}
+
+	measurement := &models.LoudnessMeasurement{}
+	for _, field := range []struct {
+		value  string
+		target *float64
+	}{
+		{stats.InputI, &measurement.InputI},
+		{stats.InputTP, &measurement.InputTP},
+		{stats.InputLRA, &measurement.InputLRA},
+		{stats.InputThresh, &measurement.InputThresh},
+		{stats.TargetOffset, &measurement.TargetOffset},
+	} {
+		n, err := strconv.ParseFloat(strings.TrimSpace(field.value), 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+			// Silent files are measured as -inf and can't be normalized.
+			return nil, fmt.Errorf("invalid loudnorm measurement %q", field.value)
+		}
+		*field.target = n
+	}
+	return measurement, nil
+}
+
+// encoderArgs re-encodes processed audio in the codec and container of the
+// original file.
+func encoderArgs(ext string) []string {
+	var args []string
+	switch ext {
+	case "mp3":
+		args = []string{"-c:a", "libmp3lame", "-b:a", "128k"}
+	case "opus", "webm":
+		args = []string{"-c:a", "libopus", "-b:a", "128k"}
+	case "ogg", "oga":
+		args = []string{"-c:a", "libvorbis", "-b:a", "128k"}
+	case "flac":
+		args = []string{"-c:a", "flac"}
+	default:
+		args = []string{"-c:a", "aac", "-b:a", "128k"}
+	}
+	return append(args, "-f", outputFormat(ext))
+}
+
+// outputFormat returns the ffmpeg muxer for a file extension. It has to be
+// given explicitly as the output is written to a .part file.
+func outputFormat(ext string) string {
+	switch ext {
+	case "mp3", "flac", "webm":
+		return ext
+	case "opus", "ogg", "oga":
+		return "ogg"
+	default:
+		return "mp4"
+	}
+}
+
+func formatLoudness(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
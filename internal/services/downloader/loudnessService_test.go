@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"strings"
+	"testing"
+)
+
+const loudnormOutput = `[Parsed_loudnorm_0 @ 0x5581] 
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-16.58",
+	"output_tp" : "-1.50",
+	"output_lra" : "14.78",
+	"output_thresh" : "-27.71",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.58"
+}
+`
+
+func TestParseLoudnormStats(t *testing.T) {
+	measurement, err := parseLoudnormStats([]byte(loudnormOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if measurement.InputI != -27.61 || measurement.InputTP != -4.47 || measurement.InputLRA != 18.06 ||
+		measurement.InputThresh != -39.2 || measurement.TargetOffset != 0.58 {
+		t.Errorf("unexpected measurement %+v", measurement)
+	}
+
+	if _, err := parseLoudnormStats([]byte(strings.Replace(loudnormOutput, `"-27.61"`, `"-inf"`, 1))); err == nil {
+		t.Error("expected a silent file to fail the measurement")
+	}
+}
+
+func TestLoudnormFilter(t *testing.T) {
+	if got := loudnormFilter(-16, -1.5, nil); got != "loudnorm=I=-16.00:TP=-1.50:LRA=11.00:print_format=json" {
+		t.Errorf("unexpected first pass %q", got)
+	}
+	measured := &models.LoudnessMeasurement{InputI: -27.61, InputTP: -4.47, InputLRA: 18.06, InputThresh: -39.2, TargetOffset: 0.58}
+	got := loudnormFilter(-16, -1.5, measured)
+	if !strings.Contains(got, ":measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.20:offset=0.58:linear=true") {
+		t.Errorf("unexpected second pass %q", got)
+	}
+}
+
+func TestLoudnessUpToDate(t *testing.T) {
+	measurement := &models.LoudnessMeasurement{TargetLufs: -16, TruePeak: -1.5, FileSize: 1000}
+	if !loudnessUpToDate(measurement, 1000, -16, -1.5) {
+		t.Error("expected the normalized file to be skipped")
+	}
+	if loudnessUpToDate(measurement, 2000, -16, -1.5) {
+		t.Error("expected a new download to be normalized")
+	}
+	if loudnessUpToDate(measurement, 1000, -14, -1.5) {
+		t.Error("expected a changed target to normalize again")
+	}
+	if loudnessUpToDate(nil, 1000, -16, -1.5) {
+		t.Error("expected an unmeasured file to be normalized")
+	}
+}
@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/services/chapters"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// downloadMarked downloads the uncut audio or video of a video into mediaDir
+// and embeds chapters marking the segments of the given categories, so
+// chapter-aware players can skip them while nothing is lost when a segment
+// was submitted wrong.
+func downloadMarked(ctx context.Context, youtubeVideoId string, media enum.MediaType, mediaDir string, categories []string) error {
+	if err := downloadMedia(ctx, youtubeVideoId, media, mediaDir, nil); err != nil {
+		return err
+	}
+	episode, err := database.GetEpisodeByVideoId(youtubeVideoId)
+	if err != nil {
+		return err
+	}
+	marked := chapters.MarkedChapters(episode, categories)
+	if len(marked) == 0 {
+		return nil
+	}
+	filePath := database.FindFileWithId(mediaDir, youtubeVideoId)
+	if filePath == "" {
+		return fmt.Errorf("no file to mark for %s", youtubeVideoId)
+	}
+	return embedChapters(ctx, filePath, marked)
+}
+
+// remarkFile writes the chapters marking the current segments of the given
+// categories into a cached marked file, which may only be kept by the storage
+// backend, and stores it again.
+func remarkFile(ctx context.Context, youtubeVideoId string, filePath string, categories []string) error {
+	episode, err := database.GetEpisodeByVideoId(youtubeVideoId)
+	if err != nil {
+		return err
+	}
+	release, err := storage.Fetch(filePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := embedChapters(ctx, filePath, chapters.MarkedChapters(episode, categories)); err != nil {
+		return err
+	}
+	return storage.Store(filePath)
+}
+
+// embedChapters writes the chapters into the file with ffmpeg, replacing any
+// it had, without re-encoding.
+func embedChapters(ctx context.Context, filePath string, chapterList []chapters.Chapter) error {
+	metadata, err := os.CreateTemp("", "chapters-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(metadata.Name())
+	_, err = metadata.WriteString(ffmetadata(chapterList, probeDuration(filePath)))
+	if closeErr := metadata.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	ext := strings.TrimPrefix(filepath.Ext(filePath), ".")
+	tempPath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".temp." + ext
+	out, err := exec.CommandContext(ctx, ffmpegBinary(),
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", filePath, "-i", metadata.Name(),
+		"-map", "0", "-map_metadata", "0", "-map_chapters", "1",
+		"-c", "copy", "-f", outputFormat(ext), tempPath,
+	).CombinedOutput()
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(tempPath, filePath)
+}
+
+// ffmetadata formats chapters as an ffmpeg metadata file. Each chapter ends
+// where the next one starts and the last one at the end of the file.
+func ffmetadata(chapterList []chapters.Chapter, duration float64) string {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, chapter := range chapterList {
+		end := duration
+		if i+1 < len(chapterList) {
+			end = chapterList[i+1].StartTime
+		}
+		// Without a duration the last chapter still needs an end.
+		end = math.Max(end, chapter.StartTime+1)
+		b.WriteString("[CHAPTER]\nTIMEBASE=1/1000\n")
+		b.WriteString("START=" + strconv.FormatInt(int64(chapter.StartTime*1000), 10) + "\n")
+		b.WriteString("END=" + strconv.FormatInt(int64(end*1000), 10) + "\n")
+		b.WriteString("title=" + escapeFFmetadata(chapter.Title) + "\n")
+	}
+	return b.String()
+}
+
+// escapeFFmetadata escapes the characters that have a meaning in an ffmpeg
+// metadata file.
+func escapeFFmetadata(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(value)
+}
@@ -0,0 +1,19 @@
+package downloader
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/services/chapters"
+	"testing"
+)
+
+func TestFfmetadata(t *testing.T) {
+	got := ffmetadata([]chapters.Chapter{
+		{StartTime: 0, Title: "Episode"},
+		{StartTime: 12.5, Title: "Sponsor; a=b"},
+	}, 60)
+	want := ";FFMETADATA1\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=12500\ntitle=Episode\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=12500\nEND=60000\ntitle=Sponsor\\; a\\=b\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
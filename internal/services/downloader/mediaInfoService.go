@@ -0,0 +1,176 @@
+package downloader
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/labstack/gommon/log"
+	"github.com/lrstanley/go-ytdlp"
+)
+
+// maxMediaInfoEstimates caps how many episodes are looked up with yt-dlp per
+// feed refresh, to stay clear of YouTube rate limits.
+const maxMediaInfoEstimates = 10
+
+var estimatingPodcasts = &sync.Map{}
+
+// RecordMediaInfo stores the real container, codec and byte size of a
+// downloaded episode so feeds can advertise accurate enclosures.
+func RecordMediaInfo(youtubeVideoId string) {
+	filePath := database.FindFileWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId)
+	if filePath == "" {
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		log.Warnf("[MEDIA INFO] Unable to stat %s: %v", filePath, err)
+		return
+	}
+
+	ext := strings.TrimPrefix(filepath.Ext(filePath), ".")
+	codec := probeAudioCodec(filePath)
+	if codec == "" {
+		codec = common.AudioCodecFromExtension(ext)
+	}
+	database.UpdateEpisodeMediaInfo(youtubeVideoId, ext, codec, info.Size())
+}
+
+// EstimateMediaInfo looks up, in the background, the format yt-dlp would
+// download for the newest episodes of a podcast that have no media info yet
+// and stores the expected container, codec and size.
+func EstimateMediaInfo(podcastId string) {
+	if _, running := estimatingPodcasts.LoadOrStore(podcastId, true); running {
+		return
+	}
+
+	go func() {
+		defer estimatingPodcasts.Delete(podcastId)
+
+		episodes, err := database.GetEpisodesWithoutMediaInfo(podcastId, maxMediaInfoEstimates)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		for _, episode := range episodes {
+			estimateEpisodeMediaInfo(episode.YoutubeVideoId)
+		}
+	}()
+}
+
+func estimateEpisodeMediaInfo(youtubeVideoId string) {
+	dl := ytdlp.New().
+		Format(audioFormat).
+		DumpJSON().
+		NoPlaylist()
+	applyYtdlpOptions(dl)
+
+	ctx, cancel := downloadContext()
+	defer cancel()
+	r, err := dl.Run(ctx, youtubeVideoUrl+youtubeVideoId)
+	if err != nil {
+		log.Warnf("[MEDIA INFO] Unable to look up formats for %s: %v", youtubeVideoId, err)
+		return
+	}
+	infos, err := r.GetExtractedInfo()
+	if err != nil || len(infos) == 0 || infos[0].ExtractedFormat == nil {
+		log.Warnf("[MEDIA INFO] No format info returned for %s", youtubeVideoId)
+		return
+	}
+
+	info := infos[0]
+	format := info.ExtractedFormat
+	acodec := ""
+	if format.ACodec != nil {
+		acodec = *format.ACodec
+	}
+	ext := info.Extension
+	if format.Extension != nil {
+		ext = *format.Extension
+	}
+
+	var size int64
+	switch {
+	case format.FileSize != nil:
+		size = int64(*format.FileSize)
+	case format.FileSizeApprox != nil:
+		size = int64(*format.FileSizeApprox)
+	case format.ABR != nil && info.Duration != nil:
+		size = int64(*format.ABR * 1000 / 8 * *info.Duration)
+	}
+	if size == 0 {
+		return
+	}
+
+	// The file may have been downloaded in the meantime, don't overwrite the
+	// real values with an estimate.
+	if database.FileExistsWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId) {
+		return
+	}
+	ext, codec := extractedAudioFormat(ext, acodec)
+	database.UpdateEpisodeMediaInfo(youtubeVideoId, ext, codec, size)
+}
+
+// extractedAudioFormat returns the file extension and codec that ExtractAudio
+// produces for a downloaded format. The audio stream is copied, not
+// re-encoded, so only the container can change.
+func extractedAudioFormat(ext string, acodec string) (string, string) {
+	switch {
+	case strings.HasPrefix(acodec, "mp4a"):
+		return "m4a", "aac"
+	case acodec == "opus":
+		return "opus", "opus"
+	case acodec == "vorbis":
+		return "ogg", "vorbis"
+	case acodec == "mp3":
+		return "mp3", "mp3"
+	}
+	return ext, common.AudioCodecFromExtension(ext)
+}
+
+func probeAudioCodec(filePath string) string {
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return ""
+	}
+	out, err := exec.Command(ffprobe,
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		filePath,
+	).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// probeDuration returns the duration of a media file in seconds, or 0 when
+// it can't be read.
+func probeDuration(filePath string) float64 {
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return 0
+	}
+	out, err := exec.Command(ffprobe,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		filePath,
+	).Output()
+	if err != nil {
+		return 0
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0
+	}
+	return duration
+}
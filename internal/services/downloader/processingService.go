@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/labstack/gommon/log"
+)
+
+const (
+	// silenceThreshold is the level below which audio counts as silence.
+	silenceThreshold = "-50dB"
+	// minSilence is the shortest silence, in seconds, that is removed.
+	minSilence = 1.0
+	// silencePadding is kept at both ends of a removed silence so sentences
+	// don't run into each other.
+	silencePadding = 0.25
+)
+
+var (
+	silenceStartRegex = regexp.MustCompile(`silence_start:\s*(-?[\d.]+)`)
+	silenceEndRegex   = regexp.MustCompile(`silence_end:\s*(-?[\d.]+)`)
+)
+
+// ProcessAudio applies the audio processing of the episode's podcast to its
+// downloaded audio and records how it was processed.
+//
+// Silences are found with ffmpeg's silencedetect and cut like sponsor
+// segments, rather than with silenceremove, so the removed ranges are known
+// and chapters and transcripts can be shifted to match. The tempo is changed
+// with atempo, which preserves the pitch.
+func ProcessAudio(ctx context.Context, youtubeVideoId string) error {
+	settings := database.GetPodcastProcessing(youtubeVideoId)
+	if !settings.Enabled() {
+		return nil
+	}
+	filePath := database.FindFileWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId)
+	if filePath == "" {
+		return nil
+	}
+
+	processed := &models.ProcessedAudio{
+		YoutubeVideoId: youtubeVideoId,
+		RemoveSilence:  settings.RemoveSilence,
+		Tempo:          settings.Tempo,
+	}
+	if settings.RemoveSilence {
+		log.Infof("[PROCESSING] Detecting silences in %s...", youtubeVideoId)
+		out, err := exec.CommandContext(ctx, ffmpegBinary(), "-hide_banner", "-nostats", "-i", filePath, "-vn",
+			"-af", fmt.Sprintf("silencedetect=noise=%s:d=%s", silenceThreshold, strconv.FormatFloat(minSilence, 'f', -1, 64)),
+			"-f", "null", "-").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		processed.Silences = parseSilences(out)
+	}
+
+	filters := processingFilters(processed)
+	if len(filters) == 0 {
+		// Nothing long enough to remove.
+		processed.Duration = probeDuration(filePath)
+		return database.SaveProcessedAudio(processed)
+	}
+
+	log.Infof("[PROCESSING] Removing %d silences from %s at %.2fx...", len(processed.Silences), youtubeVideoId, settings.Speed())
+	ext := strings.TrimPrefix(filepath.Ext(filePath), ".")
+	partPath := filePath + ".part"
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", filePath, "-vn", "-map_metadata", "0",
+		"-af", strings.Join(filters, ",")}
+	args = append(args, encoderArgs(ext)...)
+	if out, err := exec.CommandContext(ctx, ffmpegBinary(), append(args, partPath)...).CombinedOutput(); err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		return err
+	}
+	processed.Duration = probeDuration(filePath)
+	return database.SaveProcessedAudio(processed)
+}
+
+// AudioProcessingOutdated reports whether the cached audio of a video was
+// processed differently from what its podcast asks for now, in which case it
+// has to be downloaded again.
+func AudioProcessingOutdated(youtubeVideoId string) bool {
+	if !database.FileExistsWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId) {
+		return false
+	}
+	settings := database.GetPodcastProcessing(youtubeVideoId)
+	processed := database.GetProcessedAudio(youtubeVideoId)
+	if processed == nil {
+		return settings.Enabled()
+	}
+	return processed.RemoveSilence != settings.RemoveSilence || processed.Settings().Speed() != settings.Speed()
+}
+
+// processingFilters builds the ffmpeg audio filters applying the processing.
+func processingFilters(processed *models.ProcessedAudio) []string {
+	var filters []string
+	if len(processed.Silences) > 0 {
+		filters = append(filters, cutFilter(processed.Silences))
+	}
+	if speed := processed.Settings().Speed(); speed != 1 {
+		filters = append(filters, "atempo="+strconv.FormatFloat(speed, 'f', -1, 64))
+	}
+	return filters
+}
+
+// parseSilences reads the silences silencedetect printed and returns the
+// ranges to remove, keeping some padding at both ends. A silence that lasts
+// until the end of the file is left alone.
+func parseSilences(output []byte) [][2]float64 {
+	var silences [][2]float64
+	start := -1.0
+	for _, line := range strings.Split(string(output), "\n") {
+		if match := silenceStartRegex.FindStringSubmatch(line); match != nil {
+			start, _ = strconv.ParseFloat(match[1], 64)
+			if start < 0 {
+				start = 0
+			}
+			continue
+		}
+		match := silenceEndRegex.FindStringSubmatch(line)
+		if match == nil || start < 0 {
+			continue
+		}
+		end, _ := strconv.ParseFloat(match[1], 64)
+		if end-start > 2*silencePadding {
+			silences = append(silences, [2]float64{start + silencePadding, end - silencePadding})
+		}
+		start = -1
+	}
+	return silences
+}
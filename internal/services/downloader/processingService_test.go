@@ -0,0 +1,51 @@
+package downloader
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"math"
+	"strings"
+	"testing"
+)
+
+const silencedetectOutput = `[silencedetect @ 0x55d1] silence_start: 10.5
+[silencedetect @ 0x55d1] silence_end: 13.5 | silence_duration: 3
+[silencedetect @ 0x55d1] silence_start: 20
+[silencedetect @ 0x55d1] silence_end: 20.4 | silence_duration: 0.4
+[silencedetect @ 0x55d1] silence_start: 95.2
+`
+
+func TestParseSilences(t *testing.T) {
+	silences := parseSilences([]byte(silencedetectOutput))
+	// The padding swallows the short silence and the last one runs until the
+	// end of the file.
+	if len(silences) != 1 || silences[0] != [2]float64{10.75, 13.25} {
+		t.Errorf("unexpected silences %v", silences)
+	}
+}
+
+func TestProcessingFilters(t *testing.T) {
+	processed := &models.ProcessedAudio{RemoveSilence: true, Tempo: 1.25, Silences: [][2]float64{{10.75, 13.25}}}
+	filters := strings.Join(processingFilters(processed), ",")
+	if filters != "aselect='not(between(t,10.750,13.250))',asetpts=N/SR/TB,atempo=1.25" {
+		t.Errorf("unexpected filters %q", filters)
+	}
+
+	if filters := processingFilters(&models.ProcessedAudio{RemoveSilence: true}); len(filters) != 0 {
+		t.Errorf("expected nothing to do without silences, got %v", filters)
+	}
+}
+
+func TestProcessedAudioMapTime(t *testing.T) {
+	processed := &models.ProcessedAudio{Tempo: 2, Silences: [][2]float64{{10, 14}, {20, 21}}}
+	cases := map[float64]float64{
+		5:  2.5,
+		12: 5,
+		16: 6,
+		30: 12.5,
+	}
+	for timestamp, expected := range cases {
+		if got := processed.MapTime(timestamp); math.Abs(got-expected) > 1e-9 {
+			t.Errorf("MapTime(%v) = %v, want %v", timestamp, got, expected)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/labstack/gommon/log"
+)
+
+// downloadAudioFromSource cuts the audio of a video from its uncut source
+// into audioDir, downloading the source first when it isn't kept yet. Once
+// the source is kept, new sponsor segments or other categories only need a
+// local ffmpeg pass instead of another download.
+func downloadAudioFromSource(ctx context.Context, youtubeVideoId string, audioDir string, categories []string) error {
+	sourceDir := config.AppConfig.Setup.SourceDir
+	source := database.FindFileWithId(sourceDir, youtubeVideoId)
+	if source == "" {
+		if err := runYtdlp(ctx, youtubeVideoId, enum.AUDIO, sourceDir, nil); err != nil {
+			return err
+		}
+		source = database.FindFileWithId(sourceDir, youtubeVideoId)
+		if source == "" {
+			return fmt.Errorf("yt-dlp did not produce a source file for %s", youtubeVideoId)
+		}
+		// Retention counts from the download, not from the upload date
+		// yt-dlp may have set.
+		now := time.Now()
+		if err := os.Chtimes(source, now, now); err != nil {
+			log.Warn(err)
+		}
+		// The source is handed over to the storage backend once it is cut.
+		defer func() {
+			if err := storage.Store(source); err != nil {
+				log.Warnf("[SOURCE] Unable to store the source audio of %s: %v", youtubeVideoId, err)
+			}
+		}()
+	} else {
+		log.Infof("[SOURCE] Re-cutting %s from the kept source audio...", youtubeVideoId)
+		release, err := storage.Fetch(source)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	return cutSourceAudio(ctx, youtubeVideoId, source, audioDir, categories)
+}
+
+// cutSourceAudio writes the audio of a video with the segments of the given
+// categories removed from its source to audioDir.
+func cutSourceAudio(ctx context.Context, youtubeVideoId string, source string, audioDir string, categories []string) error {
+	if err := os.MkdirAll(audioDir, 0755); err != nil {
+		return err
+	}
+	ext := strings.TrimPrefix(filepath.Ext(source), ".")
+	target := filepath.Join(audioDir, youtubeVideoId+"."+ext)
+	partPath := target + ".part"
+
+	cuts := sponsorblock.MergeSegments(sponsorblock.GetSponsorSegmentsFor(youtubeVideoId, categories))
+	out, err := exec.CommandContext(ctx, ffmpegBinary(), ffmpegCutArgs(source, partPath, ext, cuts)...).CombinedOutput()
+	if err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(partPath, target)
+}
+
+// ffmpegCutArgs builds the ffmpeg arguments that remove the given ranges
+// from source. The audio is only re-encoded when something has to be cut.
+func ffmpegCutArgs(source string, target string, ext string, cuts [][2]float64) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", source, "-vn", "-map_metadata", "0"}
+	if len(cuts) == 0 {
+		args = append(args, "-c:a", "copy", "-f", outputFormat(ext))
+	} else {
+		args = append(args, "-af", cutFilter(cuts))
+		args = append(args, encoderArgs(ext)...)
+	}
+	return append(args, target)
+}
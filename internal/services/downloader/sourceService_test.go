@@ -0,0 +1,18 @@
+package downloader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFfmpegCutArgs(t *testing.T) {
+	args := strings.Join(ffmpegCutArgs("source/abc.opus", "audio/abc.opus.part", "opus", nil), " ")
+	if !strings.HasSuffix(args, "-c:a copy -f ogg audio/abc.opus.part") {
+		t.Errorf("expected the source to be copied when nothing is cut, got %q", args)
+	}
+
+	args = strings.Join(ffmpegCutArgs("source/abc.m4a", "audio/abc.m4a.part", "m4a", [][2]float64{{5, 10}}), " ")
+	if !strings.Contains(args, "-af aselect='not(between(t,5.000,10.000))',asetpts=N/SR/TB -c:a aac -b:a 128k -f mp4 audio/abc.m4a.part") {
+		t.Errorf("unexpected cut args %q", args)
+	}
+}
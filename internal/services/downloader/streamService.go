@@ -0,0 +1,264 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/labstack/gommon/log"
+	"github.com/lrstanley/go-ytdlp"
+)
+
+const (
+	// streamExtension is the container of streamed downloads. It is written
+	// as fragmented MP4 so it can be played before it is complete.
+	streamExtension = "m4a"
+	// streamPollInterval is how often followers check for newly written data.
+	streamPollInterval = 250 * time.Millisecond
+)
+
+// AudioStream is an episode that is being downloaded and cut while clients
+// play it. The output is written to a .part file in the audio directory,
+// which followers read as it grows; once complete it is renamed in place.
+type AudioStream struct {
+	partPath string
+	done     chan struct{}
+	err      error
+}
+
+// ContentType is the MIME type of the streamed audio.
+func (s *AudioStream) ContentType() string {
+	return "audio/mp4"
+}
+
+var streams = &sync.Map{}
+
+// StreamYoutubeAudio starts downloading the audio of a video with the
+// sponsor segments removed and returns a stream that can be played while it
+// is being written. A stream that is already running is joined. It returns
+// nil when the file is already downloaded or a queue worker is downloading
+// it, in which case the file has to be waited on with GetYoutubeVideo. Audio
+// that is processed or loudness normalized after the download isn't streamed
+// either, as the stream wouldn't match the feed, and neither is audio that
+// can be cut from a kept source, which is nearly instant.
+func StreamYoutubeAudio(youtubeVideoId string) *AudioStream {
+	if existing, ok := streams.Load(youtubeVideoId); ok {
+		return existing.(*AudioStream)
+	}
+	audioDir := config.AppConfig.Setup.AudioDir
+	if database.FileExistsWithId(audioDir, youtubeVideoId) || database.GetPodcastProcessing(youtubeVideoId).Enabled() {
+		return nil
+	}
+	if config.AppConfig.Loudness.Enabled {
+		return nil
+	}
+	if config.AppConfig.Ytdlp.KeepSourceAudio && database.FileExistsWithId(config.AppConfig.Setup.SourceDir, youtubeVideoId) {
+		return nil
+	}
+
+	job, err := database.StartDownloadJob(youtubeVideoId, enum.AUDIO)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	if job == nil {
+		if existing, ok := streams.Load(youtubeVideoId); ok {
+			return existing.(*AudioStream)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(audioDir, 0755); err != nil {
+		log.Error(err)
+	}
+	stream := &AudioStream{
+		partPath: filepath.Join(audioDir, youtubeVideoId+"."+streamExtension+".part"),
+		done:     make(chan struct{}),
+	}
+	file, err := os.Create(stream.partPath)
+	if err != nil {
+		log.Errorf("[STREAM] Unable to create %s: %v", stream.partPath, err)
+		finishStreamJob(job, err)
+		return nil
+	}
+	streams.Store(youtubeVideoId, stream)
+
+	queue.running.Add(1)
+	go func() {
+		defer queue.running.Done()
+		ctx, cancel := downloadContext()
+		defer cancel()
+
+		log.Infof("[STREAM] Streaming download of %s...", youtubeVideoId)
+		stream.err = runStreamPipeline(ctx, youtubeVideoId, file)
+		file.Close()
+
+		if stream.err == nil {
+			stream.err = os.Rename(stream.partPath, strings.TrimSuffix(stream.partPath, ".part"))
+		}
+		if stream.err != nil {
+			log.Errorf("[STREAM] Streaming download of %s failed: %v", youtubeVideoId, stream.err)
+			os.Remove(stream.partPath)
+		} else {
+			processAudio(ctx, youtubeVideoId)
+			if err := storeFile(audioDir, youtubeVideoId); err != nil {
+				log.Errorf("[STREAM] Unable to store %s: %v", youtubeVideoId, err)
+			}
+		}
+		finishStreamJob(job, stream.err)
+
+		streams.Delete(youtubeVideoId)
+		close(stream.done)
+		if stream.err == nil {
+			database.EnforceCacheBudget()
+			fetchSubtitles(youtubeVideoId)
+		}
+	}()
+	return stream
+}
+
+// Follow copies the stream to w as it is written, returning once the
+// download has finished or ctx is cancelled.
+func (s *AudioStream) Follow(ctx context.Context, w io.Writer) error {
+	file, err := os.Open(s.partPath)
+	if os.IsNotExist(err) {
+		// The download finished and was renamed before the follower started.
+		file, err = os.Open(strings.TrimSuffix(s.partPath, ".part"))
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 32*1024)
+	finished := false
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if finished {
+			return s.err
+		}
+
+		select {
+		case <-s.done:
+			// Read whatever was written between the last read and the end.
+			finished = true
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(streamPollInterval):
+		}
+	}
+}
+
+// runStreamPipeline pipes the audio yt-dlp downloads through ffmpeg, which
+// drops the sponsor segments, and writes the result to out.
+func runStreamPipeline(ctx context.Context, youtubeVideoId string, out io.Writer) error {
+	dl := ytdlp.New().
+		Format(audioFormat).
+		NoPlaylist().
+		NoProgress().
+		Quiet().
+		Output("-")
+	applyYtdlpOptions(dl)
+	ytdlpCmd := dl.BuildCommand(ctx, youtubeVideoUrl+youtubeVideoId)
+
+	ffmpegCmd := exec.CommandContext(ctx, ffmpegBinary(), ffmpegStreamArgs(sponsorblock.MergeSegments(sponsorblock.GetSponsorSegments(youtubeVideoId)))...)
+	audio, err := ytdlpCmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	ffmpegCmd.Stdin = audio
+	ffmpegCmd.Stdout = out
+	var ffmpegErr strings.Builder
+	ffmpegCmd.Stderr = &ffmpegErr
+
+	if err := ytdlpCmd.Start(); err != nil {
+		return err
+	}
+	if err := ffmpegCmd.Start(); err != nil {
+		ytdlpCmd.Process.Kill()
+		ytdlpCmd.Wait()
+		return err
+	}
+
+	if err := ffmpegCmd.Wait(); err != nil {
+		ytdlpCmd.Process.Kill()
+		ytdlpCmd.Wait()
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(ffmpegErr.String()))
+	}
+	if err := ytdlpCmd.Wait(); err != nil {
+		return fmt.Errorf("yt-dlp: %v", err)
+	}
+	return nil
+}
+
+// ffmpegStreamArgs builds the ffmpeg arguments that read audio from stdin,
+// remove the given ranges and write fragmented MP4 to stdout. The audio is
+// only re-encoded when something has to be cut.
+func ffmpegStreamArgs(cuts [][2]float64) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", "pipe:0", "-vn"}
+	if len(cuts) == 0 {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-af", cutFilter(cuts), "-c:a", "aac", "-b:a", "128k")
+	}
+	return append(args, "-f", "mp4", "-movflags", "frag_keyframe+empty_moov+default_base_moof", "pipe:1")
+}
+
+// cutFilter builds the ffmpeg audio filter that drops the given ranges and
+// closes the gaps they leave.
+func cutFilter(cuts [][2]float64) string {
+	conditions := make([]string, 0, len(cuts))
+	for _, cut := range cuts {
+		conditions = append(conditions, fmt.Sprintf("between(t,%.3f,%.3f)", cut[0], cut[1]))
+	}
+	return fmt.Sprintf("aselect='not(%s)',asetpts=N/SR/TB", strings.Join(conditions, "+"))
+}
+
+// finishStreamJob records the outcome of a streamed download. A failed
+// stream is handed back to the download queue to be retried the normal way.
+func finishStreamJob(job *models.DownloadJob, err error) {
+	// A stream cut short by a shutdown doesn't count as an attempt.
+	if err == nil || !queue.interrupted() {
+		job.Attempts++
+	}
+	if err == nil {
+		job.State = string(enum.DONE)
+		job.LastError = ""
+	} else {
+		job.State = string(enum.QUEUED)
+		job.LastError = err.Error()
+		job.NextAttemptAt = time.Now()
+	}
+	if updateErr := database.UpdateDownloadJob(job); updateErr != nil {
+		log.Error(updateErr)
+	}
+	queue.release(job.YoutubeVideoId, enum.AUDIO, "", "")
+	queue.notify()
+}
+
+func ffmpegBinary() string {
+	if path, err := exec.LookPath("ffmpeg"); err == nil {
+		return path
+	}
+	return "/usr/bin/ffmpeg"
+}
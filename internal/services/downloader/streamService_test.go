@@ -0,0 +1,52 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFfmpegStreamArgs(t *testing.T) {
+	args := strings.Join(ffmpegStreamArgs(nil), " ")
+	if !strings.Contains(args, "-c:a copy") || strings.Contains(args, "aselect") {
+		t.Errorf("expected the audio to be copied when nothing is cut, got %q", args)
+	}
+
+	args = strings.Join(ffmpegStreamArgs([][2]float64{{10, 20.5}, {30, 40}}), " ")
+	if !strings.Contains(args, "aselect='not(between(t,10.000,20.500)+between(t,30.000,40.000))',asetpts=N/SR/TB") {
+		t.Errorf("unexpected filter in %q", args)
+	}
+	if !strings.HasSuffix(args, "-movflags frag_keyframe+empty_moov+default_base_moof pipe:1") {
+		t.Errorf("expected fragmented MP4 on stdout, got %q", args)
+	}
+}
+
+func TestAudioStreamFollow_ReadsUntilDone(t *testing.T) {
+	partPath := filepath.Join(t.TempDir(), "video1.m4a.part")
+	file, err := os.Create(partPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := &AudioStream{partPath: partPath, done: make(chan struct{})}
+
+	go func() {
+		file.WriteString("first ")
+		time.Sleep(2 * streamPollInterval)
+		file.WriteString("second")
+		file.Close()
+		os.Rename(partPath, strings.TrimSuffix(partPath, ".part"))
+		close(stream.done)
+	}()
+
+	var out bytes.Buffer
+	if err := stream.Follow(context.Background(), &out); err != nil {
+		t.Fatalf("Follow returned error: %v", err)
+	}
+	if out.String() != "first second" {
+		t.Fatalf("expected the whole stream, got %q", out.String())
+	}
+}
@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/labstack/gommon/log"
+	"github.com/lrstanley/go-ytdlp"
+)
+
+// noSubtitlesSuffix marks a video that has been checked and has no captions
+// in the configured language, so it isn't looked up again.
+const noSubtitlesSuffix = ".none"
+
+// subtitleFetches holds the caption fetches that are running, each closed
+// once it is over. Entries are removed when their fetch ends.
+var (
+	subtitleMu      sync.Mutex
+	subtitleFetches = map[string]chan struct{}{}
+)
+
+// GetSubtitles returns the path of the WebVTT captions of a video, with the
+// original (uncut) timings. Manual captions are preferred over auto-generated
+// ones. It returns an empty string when the video has no captions. A fetch
+// that is already running for the video is waited on.
+func GetSubtitles(youtubeVideoId string) string {
+	transcriptDir := config.AppConfig.Setup.TranscriptDir
+	var fetch chan struct{}
+	for {
+		if filePath, checked := findSubtitles(transcriptDir, youtubeVideoId); checked {
+			return filePath
+		}
+		subtitleMu.Lock()
+		running, ok := subtitleFetches[youtubeVideoId]
+		if !ok {
+			fetch = make(chan struct{})
+			subtitleFetches[youtubeVideoId] = fetch
+		}
+		subtitleMu.Unlock()
+		if !ok {
+			break
+		}
+		<-running
+	}
+	defer func() {
+		subtitleMu.Lock()
+		delete(subtitleFetches, youtubeVideoId)
+		subtitleMu.Unlock()
+		close(fetch)
+	}()
+
+	language := config.AppConfig.Ytdlp.SubtitleLanguage
+	dl := ytdlp.New().
+		SkipDownload().
+		WriteSubs().
+		WriteAutoSubs().
+		SubLangs(language + ".*," + language).
+		SubFormat("vtt").
+		NoPlaylist().
+		Paths(transcriptDir).
+		Output(youtubeVideoId + ".%(ext)s")
+	applyYtdlpOptions(dl)
+
+	ctx, cancel := downloadContext()
+	defer cancel()
+	if _, err := dl.Run(ctx, youtubeVideoUrl+youtubeVideoId); err != nil {
+		log.Warnf("[TRANSCRIPT] Unable to download captions for %s: %v", youtubeVideoId, err)
+		return ""
+	}
+
+	filePath, _ := findSubtitles(transcriptDir, youtubeVideoId)
+	if filePath == "" {
+		log.Infof("[TRANSCRIPT] No captions found for %s", youtubeVideoId)
+		if err := os.WriteFile(filepath.Join(transcriptDir, youtubeVideoId+noSubtitlesSuffix), nil, 0644); err != nil {
+			log.Warn(err)
+		}
+	}
+	database.TouchEpisode(youtubeVideoId)
+	return filePath
+}
+
+// fetchSubtitles fetches the captions of a downloaded video in the
+// background, so its feed can link the transcript without anyone waiting on
+// the download for them.
+func fetchSubtitles(youtubeVideoId string) {
+	queue.running.Add(1)
+	go func() {
+		defer queue.running.Done()
+		GetSubtitles(youtubeVideoId)
+	}()
+}
+
+// SubtitlesAvailable returns the IDs of the videos whose captions have been
+// fetched.
+func SubtitlesAvailable() map[string]bool {
+	available := map[string]bool{}
+	entries, err := os.ReadDir(config.AppConfig.Setup.TranscriptDir)
+	if err != nil {
+		return available
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasSuffix(name, ".vtt") {
+			available[strings.SplitN(name, ".", 2)[0]] = true
+		}
+	}
+	return available
+}
+
+// findSubtitles looks for the captions of a video in dir. The second return
+// value reports whether the video has been checked before, with or without
+// captions being found. The exact language is preferred over regional
+// variants such as en-US.
+func findSubtitles(dir string, youtubeVideoId string) (string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+
+	exact := youtubeVideoId + "." + config.AppConfig.Ytdlp.SubtitleLanguage + ".vtt"
+	found, checked := "", false
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case name == youtubeVideoId+noSubtitlesSuffix:
+			checked = true
+		case name == exact:
+			return filepath.Join(dir, name), true
+		case found == "" && strings.HasPrefix(name, youtubeVideoId+".") && strings.HasSuffix(name, ".vtt"):
+			found = filepath.Join(dir, name)
+		}
+	}
+	return found, checked || found != ""
+}
@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProfileExtension returns the file extension of episodes transcoded with an
+// audio profile.
+func ProfileExtension(profile config.AudioProfile) string {
+	switch profile.Codec {
+	case "mp3":
+		return "mp3"
+	case "opus":
+		return "opus"
+	case "vorbis":
+		return "ogg"
+	case "flac":
+		return "flac"
+	default:
+		return "m4a"
+	}
+}
+
+// transcodeProfile converts the downloaded audio of a video with the named
+// audio profile. The output is written to a .part file first so a failed
+// transcode never looks like a finished one.
+func transcodeProfile(ctx context.Context, youtubeVideoId string, profileName string) error {
+	profile, ok := config.AppConfig.AudioProfiles[profileName]
+	if !ok {
+		return fmt.Errorf("unknown audio profile %q", profileName)
+	}
+	source := database.FindFileWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId)
+	if source == "" {
+		return fmt.Errorf("audio of %s is not downloaded", youtubeVideoId)
+	}
+	release, err := storage.Fetch(source)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	profileDir := config.ProfileDir(profileName)
+	if err := os.MkdirAll(profileDir, 0755); err != nil {
+		return err
+	}
+	target := filepath.Join(profileDir, youtubeVideoId+"."+ProfileExtension(profile))
+	partPath := target + ".part"
+
+	out, err := exec.CommandContext(ctx, ffmpegBinary(), ffmpegProfileArgs(source, partPath, profile)...).CombinedOutput()
+	if err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(partPath, target)
+}
+
+// ffmpegProfileArgs builds the ffmpeg arguments that transcode source to
+// target with the settings of an audio profile. Settings left empty keep the
+// value of the source.
+func ffmpegProfileArgs(source string, target string, profile config.AudioProfile) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", source, "-vn", "-map_metadata", "0"}
+	switch profile.Codec {
+	case "mp3":
+		args = append(args, "-c:a", "libmp3lame")
+	case "opus":
+		args = append(args, "-c:a", "libopus")
+	case "vorbis":
+		args = append(args, "-c:a", "libvorbis")
+	case "flac":
+		args = append(args, "-c:a", "flac")
+	default:
+		args = append(args, "-c:a", "aac")
+	}
+	if profile.Bitrate != "" && profile.Codec != "flac" {
+		args = append(args, "-b:a", profile.Bitrate)
+	}
+	if profile.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(profile.Channels))
+	}
+	if profile.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(profile.SampleRate))
+	}
+
+	return append(args, "-f", outputFormat(ProfileExtension(profile)), target)
+}
@@ -0,0 +1,35 @@
+package downloader
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestFfmpegProfileArgs(t *testing.T) {
+	profile := config.AudioProfile{Codec: "mp3", Bitrate: "64k", Channels: 1, SampleRate: 44100}
+	args := strings.Join(ffmpegProfileArgs("in.m4a", "out.mp3.part", profile), " ")
+	for _, expected := range []string{"-i in.m4a", "-c:a libmp3lame", "-b:a 64k", "-ac 1", "-ar 44100"} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("expected %q in %q", expected, args)
+		}
+	}
+	// The .part suffix hides the container from ffmpeg, so it has to be given.
+	if !strings.HasSuffix(args, "-f mp3 out.mp3.part") {
+		t.Errorf("expected an explicit mp3 output, got %q", args)
+	}
+
+	args = strings.Join(ffmpegProfileArgs("in.m4a", "out.m4a.part", config.AudioProfile{Codec: "aac"}), " ")
+	if strings.Contains(args, "-b:a") || strings.Contains(args, "-ac") || !strings.HasSuffix(args, "-c:a aac -f mp4 out.m4a.part") {
+		t.Errorf("expected only the codec to be set, got %q", args)
+	}
+}
+
+func TestProfileExtension(t *testing.T) {
+	cases := map[string]string{"mp3": "mp3", "aac": "m4a", "opus": "opus", "vorbis": "ogg", "flac": "flac"}
+	for codec, ext := range cases {
+		if got := ProfileExtension(config.AudioProfile{Codec: codec}); got != ext {
+			t.Errorf("ProfileExtension(%s) = %s, want %s", codec, got, ext)
+		}
+	}
+}
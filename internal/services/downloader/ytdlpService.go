@@ -5,9 +5,10 @@ import (
 	"fmt"
 	"ikoyhn/podcast-sponsorblock/internal/config"
 	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
 	"ikoyhn/podcast-sponsorblock/internal/services/ntfy"
-	"strings"
-	"sync"
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
 	"sync/atomic"
 	"time"
 
@@ -15,24 +16,102 @@ import (
 	"github.com/lrstanley/go-ytdlp"
 )
 
-var youtubeVideoMutexes = &sync.Map{}
-
 const youtubeVideoUrl = "https://www.youtube.com/watch?v="
 
+const audioFormat = "bestaudio[ext=m4a]/bestaudio[ext=aac]/bestaudio[ext=opus]/bestaudio[ext=vorbis]/bestaudio/best"
+
+// GetYoutubeVideo downloads the audio of a video with the sponsor segments
+// removed. The returned channel is closed once the download attempt is over.
 func GetYoutubeVideo(youtubeVideoId string) <-chan struct{} {
-	mutex, ok := youtubeVideoMutexes.Load(youtubeVideoId)
-	if !ok {
-		mutex = &sync.Mutex{}
-		youtubeVideoMutexes.Store(youtubeVideoId, mutex)
+	return GetYoutubeMedia(youtubeVideoId, enum.AUDIO)
+}
+
+// GetYoutubeMedia queues a download of the audio, or the video capped at the
+// configured resolution, of a video with the sponsor segments removed. When a
+// download of the same file is already queued or running it is joined
+// instead. The returned channel is closed once the file exists or the next
+// download attempt is over.
+func GetYoutubeMedia(youtubeVideoId string, media enum.MediaType) <-chan struct{} {
+	if database.FileExistsWithId(config.MediaDir(media), youtubeVideoId) {
+		done := make(chan struct{})
+		close(done)
+		return done
 	}
+	return queue.enqueue(youtubeVideoId, media, "", "")
+}
 
-	mutex.(*sync.Mutex).Lock()
+// GetYoutubeMediaVariant queues a download of the audio or video of a video
+// with the segments of other SponsorBlock categories than its podcast's
+// removed, given as a category key. The returned channel is closed once the
+// file exists or the next download attempt is over.
+func GetYoutubeMediaVariant(youtubeVideoId string, media enum.MediaType, categories string) <-chan struct{} {
+	if database.FileExistsWithId(config.VariantDir(media, categories), youtubeVideoId) {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return queue.enqueue(youtubeVideoId, media, "", categories)
+}
 
-	if database.FileExistsWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId) {
-		mutex.(*sync.Mutex).Unlock()
-		return make(chan struct{})
+// RecutMedia queues a download of the audio or video of a video, or of a
+// variant when categories are given, that replaces the cached file once it
+// is done. Cached audio profiles are transcoded again from the new audio.
+func RecutMedia(youtubeVideoId string, media enum.MediaType, categories string) {
+	if _, err := database.EnqueueReplaceJob(youtubeVideoId, media, "", categories); err != nil {
+		log.Errorf("[DOWNLOAD QUEUE] Unable to queue %s: %v", youtubeVideoId, err)
+		return
 	}
+	queue.notify()
+}
 
+// RecutCached queues every cached file of a video to be cut again, the
+// variants included. Each file keeps being served until its new cut
+// replaces it, and audio profiles are transcoded again once the audio is.
+func RecutCached(youtubeVideoId string) {
+	for _, media := range []enum.MediaType{enum.AUDIO, enum.VIDEO} {
+		if database.FileExistsWithId(config.MediaDir(media), youtubeVideoId) {
+			RecutMedia(youtubeVideoId, media, "")
+		}
+		for _, categories := range database.GetVariantCategories() {
+			if database.FileExistsWithId(config.VariantDir(media, categories), youtubeVideoId) {
+				RecutMedia(youtubeVideoId, media, categories)
+			}
+		}
+	}
+}
+
+// GetYoutubeAudioProfile queues a transcode of the audio of a video with the
+// named audio profile, downloading the audio first when needed. The returned
+// channel is closed once the file exists or the next attempt is over.
+func GetYoutubeAudioProfile(youtubeVideoId string, profile string) <-chan struct{} {
+	if database.FileExistsWithId(config.ProfileDir(profile), youtubeVideoId) {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	if !database.FileExistsWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId) {
+		if _, err := database.EnqueueDownloadJob(youtubeVideoId, enum.AUDIO, "", ""); err != nil {
+			log.Error(err)
+		}
+	}
+	return queue.enqueue(youtubeVideoId, enum.AUDIO, profile, "")
+}
+
+// downloadMedia runs a single download job into mediaDir, with the segments
+// of the given categories removed. Audio is cut from the kept source when
+// source audio is kept.
+func downloadMedia(ctx context.Context, youtubeVideoId string, media enum.MediaType, mediaDir string, categories []string) error {
+	if media == enum.AUDIO && config.AppConfig.Ytdlp.KeepSourceAudio {
+		return downloadAudioFromSource(ctx, youtubeVideoId, mediaDir, categories)
+	}
+	return runYtdlp(ctx, youtubeVideoId, media, mediaDir, categories)
+}
+
+// runYtdlp downloads the audio or video of a video into mediaDir, with the
+// trusted segments of the given categories removed by yt-dlp, none when
+// there are no categories. yt-dlp is handed the ranges to cut rather than
+// the categories, so it cuts exactly what SponsorBlock filtering kept.
+func runYtdlp(ctx context.Context, youtubeVideoId string, media enum.MediaType, mediaDir string, categories []string) error {
 	title := youtubeVideoId
 	episode, err := database.GetEpisodeByVideoId(youtubeVideoId)
 	if err != nil {
@@ -42,54 +121,94 @@ func GetYoutubeVideo(youtubeVideoId string) <-chan struct{} {
 		title = episode.EpisodeName
 	}
 
-	categories := config.AppConfig.Ytdlp.SponsorBlockCategories
-	categories = strings.TrimSpace(categories)
-
 	var etaNotified uint32 = 0
 	dl := ytdlp.New().
 		NoProgress().
-		Format("bestaudio[ext=m4a]/bestaudio[ext=aac]/bestaudio[ext=opus]/bestaudio[ext=vorbis]/bestaudio/best").
-		SponsorblockRemove(categories).
-		ExtractAudio().
 		NoPlaylist().
 		FFmpegLocation("/usr/bin/ffmpeg").
 		Continue().
-		Paths(config.AppConfig.Setup.AudioDir).
+		Paths(mediaDir).
 		ProgressFunc(4000*time.Millisecond, func(prog ytdlp.ProgressUpdate) {
 			ytdlpProgress(&etaNotified, prog, title)
 		}).
 		Output(youtubeVideoId + ".%(ext)s")
 
+	if len(categories) > 0 {
+		for _, cut := range sponsorblock.MergeSegments(sponsorblock.GetSponsorSegmentsFor(youtubeVideoId, categories)) {
+			dl.RemoveChapters(fmt.Sprintf("*%.3f-%.3f", cut[0], cut[1]))
+		}
+	}
+	if media == enum.VIDEO {
+		dl.Format(videoFormat(config.AppConfig.Ytdlp.VideoMaxHeight)).
+			MergeOutputFormat("mp4")
+	} else {
+		dl.Format(audioFormat).
+			ExtractAudio()
+	}
+
+	applyYtdlpOptions(dl)
+
+	r, dlErr := dl.Run(ctx, youtubeVideoUrl+youtubeVideoId)
+	if r != nil && r.ExitCode == 0 {
+		log.Infof("%s download completed successfully.", title)
+		ntfy.SendNotification(fmt.Sprintf("%s download success!", title), "Clean Cast - Success")
+		return nil
+	}
+	if ctx.Err() == nil && database.FileExistsWithId(mediaDir, youtubeVideoId) {
+		ntfy.SendNotification("Download completed!", "Clean Cast - Success")
+		log.Warn("Download exited with non-zero code, but file exists: ", youtubeVideoId)
+		return nil
+	}
+	if dlErr == nil {
+		dlErr = fmt.Errorf("yt-dlp did not produce a file for %s", youtubeVideoId)
+	}
+	return dlErr
+}
+
+// processAudio post-processes newly downloaded audio and records its media
+// info. A failed step leaves the audio as it was downloaded.
+func processAudio(ctx context.Context, youtubeVideoId string) {
+	// The processing recorded for the previous download no longer applies.
+	if err := database.DeleteProcessedAudio(youtubeVideoId); err != nil {
+		log.Error(err)
+	}
+	if err := ProcessAudio(ctx, youtubeVideoId); err != nil {
+		log.Warnf("[PROCESSING] Unable to process %s: %v", youtubeVideoId, err)
+	}
+	if err := NormalizeLoudness(ctx, youtubeVideoId); err != nil {
+		log.Warnf("[LOUDNESS] Unable to normalize %s: %v", youtubeVideoId, err)
+	}
+	RecordMediaInfo(youtubeVideoId)
+}
+
+// storeFile hands the finished file of a video in dir over to the storage
+// backend.
+func storeFile(dir string, youtubeVideoId string) error {
+	filePath := database.FindFileWithId(dir, youtubeVideoId)
+	if filePath == "" {
+		return nil
+	}
+	return storage.Store(filePath)
+}
+
+// videoFormat selects an MP4 compatible video stream no taller than
+// maxHeight, merged with the best m4a audio. A maxHeight of 0 means no cap.
+func videoFormat(maxHeight int) string {
+	height := ""
+	if maxHeight > 0 {
+		height = fmt.Sprintf("[height<=%d]", maxHeight)
+	}
+	return fmt.Sprintf("bestvideo%[1]s[ext=mp4]+bestaudio[ext=m4a]/best%[1]s[ext=mp4]/bestvideo%[1]s+bestaudio/best%[1]s", height)
+}
+
+// applyYtdlpOptions adds the user configured cookies and extractor args.
+func applyYtdlpOptions(dl *ytdlp.Command) {
 	if config.AppConfig.Ytdlp.CookiesFile != "" {
 		dl.Cookies(config.AppConfig.Ytdlp.CookiesFile)
 	}
 	if config.AppConfig.Ytdlp.YtdlpExtractorArgs != "" {
 		dl.ExtractorArgs(config.AppConfig.Ytdlp.YtdlpExtractorArgs)
 	}
-
-	done := make(chan struct{})
-	go func() {
-		r, dlErr := dl.Run(context.TODO(), youtubeVideoUrl+youtubeVideoId)
-
-		if r.ExitCode != 0 {
-			if database.FileExistsWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId) {
-				ntfy.SendNotification("Download completed!", "Clean Cast - Success")
-				log.Warn("Download exited with non-zero code, but file exists: ", youtubeVideoId)
-			} else {
-				if dlErr != nil {
-					ntfy.SendNotification("Download failed!", "Clean Cast - Error")
-					log.Errorf("Error downloading YouTube video: %v", dlErr)
-				}
-			}
-		} else {
-			log.Infof("%s download completed successfully.", title)
-			ntfy.SendNotification(fmt.Sprintf("%s download success!", title), "Clean Cast - Success")
-		}
-		mutex.(*sync.Mutex).Unlock()
-		close(done)
-	}()
-
-	return done
 }
 
 func ytdlpProgress(etaNotified *uint32, prog ytdlp.ProgressUpdate, title string) {
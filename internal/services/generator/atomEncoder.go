@@ -0,0 +1,181 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Specifications: https://www.rfc-editor.org/rfc/rfc4287
+//
+
+const (
+	atomNamespace = "http://www.w3.org/2005/Atom"
+
+	// AtomContentType is the MIME type of an Atom 1.0 document.
+	AtomContentType = "application/atom+xml"
+)
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"feed"`
+	XMLNS     string      `xml:"xmlns,attr"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Subtitle  string      `xml:"subtitle,omitempty"`
+	Updated   string      `xml:"updated"`
+	Generator string      `xml:"generator,omitempty"`
+	Icon      string      `xml:"icon,omitempty"`
+	Logo      string      `xml:"logo,omitempty"`
+	Author    *atomPerson `xml:"author"`
+	Links     []atomLink  `xml:"link"`
+	Archive   *struct{}   `xml:"http://purl.org/syndication/history/1.0 archive"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length string `xml:"length,attr,omitempty"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published,omitempty"`
+	Author    *atomPerson `xml:"author"`
+	Summary   *atomText   `xml:"summary"`
+	Links     []atomLink  `xml:"link"`
+}
+
+// AtomBytes returns the Podcast encoded as an Atom 1.0 document.
+func (p *Podcast) AtomBytes() []byte {
+	b := new(bytes.Buffer)
+	if err := p.EncodeAtom(b); err != nil {
+		return []byte("AtomBytes: podcast.EncodeAtom returned the error: " + err.Error())
+	}
+	return b.Bytes()
+}
+
+// EncodeAtom writes the Podcast to the io.Writer stream in Atom 1.0
+// specification.
+//
+// Item descriptions are treated as HTML, the same way RSS readers treat the
+// RSS description, and enclosures become rel="enclosure" links.
+func (p *Podcast) EncodeAtom(w io.Writer) error {
+	if _, err := w.Write([]byte("<?xml version='1.0' encoding=\"UTF-8\"?>\n")); err != nil {
+		return errors.Wrap(err, "podcast.EncodeAtom: w.Write return error")
+	}
+
+	updated := parseRFC1123Z(p.LastBuildDate)
+	feed := atomFeed{
+		XMLNS:     atomNamespace,
+		ID:        p.feedID(),
+		Title:     p.Title,
+		Subtitle:  p.Description,
+		Updated:   formatRFC3339(updated),
+		Generator: p.Generator,
+		Links:     []atomLink{{Href: p.Link, Rel: "alternate", Type: "text/html"}},
+	}
+	if p.Image != nil {
+		feed.Icon = p.Image.URL
+		feed.Logo = p.Image.URL
+	}
+	if p.IAuthor != "" {
+		feed.Author = &atomPerson{Name: p.IAuthor}
+	}
+	for _, link := range p.AtomLinks {
+		feed.Links = append(feed.Links, atomLink{Href: link.Href, Rel: link.Rel, Type: link.Type})
+	}
+	if p.FHArchive != nil {
+		feed.Archive = &struct{}{}
+	}
+
+	for _, item := range p.Items {
+		entry := atomEntry{
+			ID:      item.entryID(),
+			Title:   item.Title,
+			Updated: formatRFC3339(updated),
+		}
+		if item.PubDate != nil && !item.PubDate.IsZero() {
+			entry.Updated = formatRFC3339(*item.PubDate)
+			entry.Published = entry.Updated
+		}
+		if item.IAuthor != "" {
+			entry.Author = &atomPerson{Name: item.IAuthor}
+		}
+		if item.Description != "" {
+			entry.Summary = &atomText{Type: "html", Value: item.Description}
+		}
+		if item.Link != "" {
+			entry.Links = append(entry.Links, atomLink{Href: item.Link, Rel: "alternate", Type: "text/html"})
+		}
+		if item.Enclosure != nil {
+			entry.Links = append(entry.Links, atomLink{
+				Href:   item.Enclosure.URL,
+				Rel:    "enclosure",
+				Type:   item.Enclosure.TypeFormatted,
+				Length: item.Enclosure.LengthFormatted,
+			})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return p.encode(w, feed)
+}
+
+// feedID returns a permanent, unique identifier for the feed, preferring the
+// podcast:guid when one is set.
+func (p *Podcast) feedID() string {
+	if p.PGUID != "" {
+		return "urn:uuid:" + p.PGUID
+	}
+	return p.Link
+}
+
+// selfLink returns the href of the rel="self" atom:link, if any.
+func (p *Podcast) selfLink() string {
+	return p.atomLink("self")
+}
+
+func (p *Podcast) atomLink(rel string) string {
+	for _, link := range p.AtomLinks {
+		if link.Rel == rel {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// entryID returns a permanent, unique identifier for the item.
+func (i *Item) entryID() string {
+	if i.Link != "" {
+		return i.Link
+	}
+	return i.GUID.Value
+}
+
+func parseRFC1123Z(value string) time.Time {
+	t, err := time.Parse(time.RFC1123Z, value)
+	if err != nil {
+		return time.Now().UTC()
+	}
+	return t
+}
+
+func formatRFC3339(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
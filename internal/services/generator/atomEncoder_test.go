@@ -0,0 +1,45 @@
+package generator
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestEncodeAtom(t *testing.T) {
+	published := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	p := New("title", "https://www.youtube.com/channel/abc", "description", &published)
+	p.AddGUID("https://www.youtube.com/channel/abc")
+	p.AddAtomLink("https://example.com/channel/abc?format=atom", "self", AtomContentType)
+	p.IAuthor = "Host"
+
+	item := Item{Title: "episode", Description: "a &amp; b", Link: "https://www.youtube.com/watch?v=vid", PubDate: &published}
+	item.AddEnclosure("https://example.com/media/vid", M4A, 42)
+	if _, err := p.AddItem(item); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(p.AtomBytes(), &feed); err != nil {
+		t.Fatalf("atom output is not valid XML: %v", err)
+	}
+	if feed.ID != "urn:uuid:"+p.PGUID || feed.Updated != "2025-06-01T12:00:00Z" {
+		t.Fatalf("unexpected feed id/updated: %s %s", feed.ID, feed.Updated)
+	}
+	if len(feed.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(feed.Entries))
+	}
+	entry := feed.Entries[0]
+	if entry.ID != "https://www.youtube.com/watch?v=vid" || entry.Summary == nil || entry.Summary.Value != "a &amp; b" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	var enclosure *atomLink
+	for i := range entry.Links {
+		if entry.Links[i].Rel == "enclosure" {
+			enclosure = &entry.Links[i]
+		}
+	}
+	if enclosure == nil || enclosure.Type != "audio/mp4" || enclosure.Length != "42" {
+		t.Fatalf("unexpected enclosure link: %+v", enclosure)
+	}
+}
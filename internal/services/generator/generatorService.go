@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/xml"
 	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
 	"io"
 	"strconv"
 	"time"
@@ -31,10 +32,17 @@ const (
 	MOV
 	PDF
 	EPUB
+	OGG
+	WEBM
+	AAC
+	FLAC
 )
 
 const (
 	enclosureDefault = "application/octet-stream"
+
+	// https://www.rfc-editor.org/rfc/rfc5005
+	feedHistoryNamespace = "http://purl.org/syndication/history/1.0"
 )
 
 // EnclosureType specifies the type of the enclosure.
@@ -58,10 +66,31 @@ func (et EnclosureType) String() string {
 		return "application/pdf"
 	case EPUB:
 		return "document/x-epub"
+	case OGG:
+		return "audio/ogg"
+	case WEBM:
+		return "audio/webm"
+	case AAC:
+		return "audio/aac"
+	case FLAC:
+		return "audio/flac"
 	}
 	return enclosureDefault
 }
 
+// EnclosureTypeFromExtension returns the EnclosureType for a file extension,
+// with or without the leading dot. Unknown extensions default to M4A, the
+// format downloads are requested in.
+func EnclosureTypeFromExtension(ext string) EnclosureType {
+	mediaType := common.MediaTypeFromExtension(ext)
+	for et := M4A; et <= FLAC; et++ {
+		if et.String() == mediaType {
+			return et
+		}
+	}
+	return M4A
+}
+
 // Enclosure represents a download enclosure.
 type Enclosure struct {
 	XMLName xml.Name `xml:"enclosure"`
@@ -148,6 +177,12 @@ type Item struct {
 	IImage             struct {
 		Href string `xml:"href,attr"`
 	} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image,omitempty"`
+
+	// https://podcastindex.org/namespace/1.0
+	PChapters    *PChapters
+	PTranscripts []*PTranscript
+	PPersons     []*PPerson
+	PSoundbites  []*PSoundbite
 }
 
 // AddEnclosure adds the downloadable asset to the podcast Item.
@@ -232,6 +267,8 @@ type Podcast struct {
 	WebMaster      string   `xml:"webMaster,omitempty"`
 	Image          *Image
 	TextInput      *TextInput
+	AtomLinks      []*AtomLink
+	FHArchive      *FHArchive
 
 	// https://help.apple.com/itc/podcasts_connect/#/itcb54353390
 	IAuthor     string `xml:"itunes:author,omitempty"`
@@ -245,11 +282,32 @@ type Podcast struct {
 	IOwner      *Author // Author is formatted for itunes as-is
 	ICategories []*ICategory
 
+	// https://podcastindex.org/namespace/1.0
+	PGUID     string `xml:"podcast:guid,omitempty"`
+	PLocked   *PLocked
+	PMedium   string `xml:"podcast:medium,omitempty"`
+	PFundings []*PFunding
+	PPersons  []*PPerson
+
 	Items []*Item `xml:"item"`
 
 	encode func(w io.Writer, o interface{}) error
 }
 
+// AtomLink represents an atom:link, e.g. the rel="self" link of a feed.
+type AtomLink struct {
+	XMLName xml.Name `xml:"atom:link"`
+	Href    string   `xml:"href,attr"`
+	Rel     string   `xml:"rel,attr"`
+	Type    string   `xml:"type,attr,omitempty"`
+}
+
+// FHArchive marks an RFC 5005 archive document, a page of a feed whose
+// contents will not change anymore.
+type FHArchive struct {
+	XMLName xml.Name `xml:"fh:archive"`
+}
+
 // TextInput represents text inputs.
 type TextInput struct {
 	XMLName     xml.Name `xml:"textInput"`
@@ -288,6 +346,22 @@ func (p *Podcast) AddAuthor(name, email string) {
 	p.IAuthor = p.ManagingEditor
 }
 
+// AddAtomLink adds an atom:link with the given relation to the Podcast.
+//
+// The rel="self" link is also used as the feed URL by the Atom and JSON Feed
+// encoders.
+func (p *Podcast) AddAtomLink(href, rel, linkType string) {
+	if len(href) == 0 {
+		return
+	}
+	p.AtomLinks = append(p.AtomLinks, &AtomLink{Href: href, Rel: rel, Type: linkType})
+}
+
+// MarkArchive flags the Podcast as an RFC 5005 archive document.
+func (p *Podcast) MarkArchive() {
+	p.FHArchive = &FHArchive{}
+}
+
 // AddCategory adds the category to the Podcast.
 //
 // ICategory can be listed multiple times.
@@ -559,8 +633,12 @@ func (p *Podcast) Encode(w io.Writer) error {
 		ITUNESNS:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
 		ATOMNS:    "http://www.w3.org/2005/Atom",
 		CONTENTNS: "http://purl.org/rss/1.0/modules/content/",
+		PODCASTNS: podcastNamespace,
 		Channel:   p,
 	}
+	if p.FHArchive != nil {
+		wrapped.FHNS = feedHistoryNamespace
+	}
 	return p.encode(w, wrapped)
 }
 
@@ -589,6 +667,8 @@ type podcastWrapper struct {
 	ATOMNS    string   `xml:"xmlns:atom,attr,omitempty"`
 	ITUNESNS  string   `xml:"xmlns:itunes,attr"`
 	CONTENTNS string   `xml:"xmlns:content,attr"`
+	PODCASTNS string   `xml:"xmlns:podcast,attr"`
+	FHNS      string   `xml:"xmlns:fh,attr,omitempty"`
 	Channel   *Podcast
 }
 
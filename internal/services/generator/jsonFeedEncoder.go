@@ -0,0 +1,121 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Specifications: https://www.jsonfeed.org/version/1.1/
+//
+
+const (
+	jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+	// JSONFeedContentType is the MIME type of a JSON Feed document.
+	JSONFeedContentType = "application/feed+json"
+)
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url,omitempty"`
+	Description string           `json:"description,omitempty"`
+	NextURL     string           `json:"next_url,omitempty"`
+	Icon        string           `json:"icon,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	Title         string               `json:"title,omitempty"`
+	ContentHTML   string               `json:"content_html"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+	URL               string `json:"url"`
+	MimeType          string `json:"mime_type"`
+	SizeInBytes       int64  `json:"size_in_bytes,omitempty"`
+	DurationInSeconds int64  `json:"duration_in_seconds,omitempty"`
+}
+
+// JSONFeedBytes returns the Podcast encoded as a JSON Feed 1.1 document.
+func (p *Podcast) JSONFeedBytes() []byte {
+	b := new(bytes.Buffer)
+	if err := p.EncodeJSONFeed(b); err != nil {
+		return []byte("JSONFeedBytes: podcast.EncodeJSONFeed returned the error: " + err.Error())
+	}
+	return b.Bytes()
+}
+
+// EncodeJSONFeed writes the Podcast to the io.Writer stream in JSON Feed 1.1
+// specification.
+//
+// Item descriptions are used as content_html and enclosures become
+// attachments.
+func (p *Podcast) EncodeJSONFeed(w io.Writer) error {
+	feed := jsonFeed{
+		Version:     jsonFeedVersion,
+		Title:       p.Title,
+		HomePageURL: p.Link,
+		FeedURL:     p.selfLink(),
+		Description: p.Description,
+		NextURL:     p.atomLink("next"),
+		Items:       []jsonFeedItem{},
+	}
+	if p.Image != nil {
+		feed.Icon = p.Image.URL
+	}
+	if p.IAuthor != "" {
+		feed.Authors = []jsonFeedAuthor{{Name: p.IAuthor}}
+	}
+
+	for _, item := range p.Items {
+		feedItem := jsonFeedItem{
+			ID:          item.entryID(),
+			URL:         item.Link,
+			Title:       item.Title,
+			ContentHTML: item.Description,
+			Image:       item.IImage.Href,
+		}
+		if item.PubDate != nil && !item.PubDate.IsZero() {
+			feedItem.DatePublished = formatRFC3339(*item.PubDate)
+		}
+		if item.IAuthor != "" {
+			feedItem.Authors = []jsonFeedAuthor{{Name: item.IAuthor}}
+		}
+		if item.Enclosure != nil {
+			duration, _ := strconv.ParseInt(item.IDuration, 10, 64)
+			feedItem.Attachments = []jsonFeedAttachment{{
+				URL:               item.Enclosure.URL,
+				MimeType:          item.Enclosure.TypeFormatted,
+				SizeInBytes:       item.Enclosure.Length,
+				DurationInSeconds: duration,
+			}}
+		}
+		feed.Items = append(feed.Items, feedItem)
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	if err := e.Encode(feed); err != nil {
+		return errors.Wrap(err, "podcast.EncodeJSONFeed: e.Encode returned error")
+	}
+	return nil
+}
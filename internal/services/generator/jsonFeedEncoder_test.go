@@ -0,0 +1,36 @@
+package generator
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeJSONFeed(t *testing.T) {
+	p := New("title", "https://www.youtube.com/playlist?list=abc", "description", nil)
+	p.AddAtomLink("https://example.com/rss/abc?format=json", "self", JSONFeedContentType)
+
+	item := Item{Title: "episode", Description: "desc", GUID: struct {
+		Value       string `xml:",chardata"`
+		IsPermaLink bool   `xml:"isPermaLink,attr"`
+	}{Value: "vid"}, IDuration: "3600"}
+	item.AddEnclosure("https://example.com/media/vid", MP3, 1000)
+	if _, err := p.AddItem(item); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+
+	var feed jsonFeed
+	if err := json.Unmarshal(p.JSONFeedBytes(), &feed); err != nil {
+		t.Fatalf("json feed output is not valid JSON: %v", err)
+	}
+	if feed.Version != jsonFeedVersion || feed.FeedURL != "https://example.com/rss/abc?format=json" {
+		t.Fatalf("unexpected feed header: %+v", feed)
+	}
+	if len(feed.Items) != 1 || len(feed.Items[0].Attachments) != 1 {
+		t.Fatalf("expected 1 item with 1 attachment, got %+v", feed.Items)
+	}
+	it := feed.Items[0]
+	attachment := it.Attachments[0]
+	if it.ID != "vid" || attachment.MimeType != "audio/mpeg" || attachment.SizeInBytes != 1000 || attachment.DurationInSeconds != 3600 {
+		t.Fatalf("unexpected item: %+v", it)
+	}
+}
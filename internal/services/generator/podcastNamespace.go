@@ -0,0 +1,186 @@
+package generator
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Specifications: https://podcastindex.org/namespace/1.0
+//
+
+const (
+	podcastNamespace = "https://podcastindex.org/namespace/1.0"
+
+	// podcastGUIDNamespace is the UUIDv5 namespace the Podcasting 2.0 spec
+	// requires for deriving podcast:guid values.
+	podcastGUIDNamespace = "ead4c236-bf58-58c6-a2c6-a6b28d128cb6"
+)
+
+// Medium values accepted by podcast:medium.
+const (
+	MediumPodcast = "podcast"
+	MediumVideo   = "video"
+)
+
+// Chapter and transcript MIME types used by the podcast namespace.
+const (
+	ChaptersJSON   = "application/json+chapters"
+	TranscriptVTT  = "text/vtt"
+	TranscriptSRT  = "application/x-subrip"
+	TranscriptJSON = "application/json"
+)
+
+// PLocked tells other platforms whether they may import the feed.
+type PLocked struct {
+	XMLName xml.Name `xml:"podcast:locked"`
+	Owner   string   `xml:"owner,attr,omitempty"`
+	Value   string   `xml:",chardata"`
+}
+
+// PFunding links to a page where listeners can support the show.
+type PFunding struct {
+	XMLName xml.Name `xml:"podcast:funding"`
+	URL     string   `xml:"url,attr"`
+	Text    string   `xml:",chardata"`
+}
+
+// PPerson credits a person involved with the show or an episode.
+type PPerson struct {
+	XMLName xml.Name `xml:"podcast:person"`
+	Role    string   `xml:"role,attr,omitempty"`
+	Group   string   `xml:"group,attr,omitempty"`
+	Img     string   `xml:"img,attr,omitempty"`
+	Href    string   `xml:"href,attr,omitempty"`
+	Name    string   `xml:",chardata"`
+}
+
+// PChapters points to an external chapters file for an episode.
+type PChapters struct {
+	XMLName xml.Name `xml:"podcast:chapters"`
+	URL     string   `xml:"url,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+// PTranscript points to an external transcript file for an episode.
+type PTranscript struct {
+	XMLName  xml.Name `xml:"podcast:transcript"`
+	URL      string   `xml:"url,attr"`
+	Type     string   `xml:"type,attr"`
+	Language string   `xml:"language,attr,omitempty"`
+	Rel      string   `xml:"rel,attr,omitempty"`
+}
+
+// PSoundbite marks a short, shareable section of an episode.
+type PSoundbite struct {
+	XMLName   xml.Name `xml:"podcast:soundbite"`
+	StartTime float64  `xml:"startTime,attr"`
+	Duration  float64  `xml:"duration,attr"`
+	Title     string   `xml:",chardata"`
+}
+
+// AddGUID sets podcast:guid to the UUIDv5 of the given feed URL.
+//
+// The scheme and trailing slashes are stripped before hashing, as required
+// by the spec, so the same feed always produces the same guid.
+func (p *Podcast) AddGUID(feedURL string) {
+	if len(feedURL) == 0 {
+		return
+	}
+	p.PGUID = podcastGUID(feedURL)
+}
+
+// AddLocked sets podcast:locked. A locked feed asks other platforms not to
+// import it.
+func (p *Podcast) AddLocked(locked bool, owner string) {
+	value := "no"
+	if locked {
+		value = "yes"
+	}
+	p.PLocked = &PLocked{Owner: owner, Value: value}
+}
+
+// AddMedium sets podcast:medium, e.g. MediumPodcast or MediumVideo.
+func (p *Podcast) AddMedium(medium string) {
+	p.PMedium = medium
+}
+
+// AddFunding appends a podcast:funding link.
+func (p *Podcast) AddFunding(url, text string) {
+	if len(url) == 0 {
+		return
+	}
+	p.PFundings = append(p.PFundings, &PFunding{URL: url, Text: text})
+}
+
+// AddPerson appends a podcast:person credit to the Podcast.
+func (p *Podcast) AddPerson(name, role, img, href string) {
+	if len(name) == 0 {
+		return
+	}
+	p.PPersons = append(p.PPersons, &PPerson{Name: name, Role: role, Img: img, Href: href})
+}
+
+// AddChapters sets the podcast:chapters link of the Item.
+func (i *Item) AddChapters(url, chaptersType string) {
+	if len(url) == 0 {
+		return
+	}
+	i.PChapters = &PChapters{URL: url, Type: chaptersType}
+}
+
+// AddTranscript appends a podcast:transcript link to the Item.
+//
+// Set rel to "captions" when the file carries timing information.
+func (i *Item) AddTranscript(url, transcriptType, language, rel string) {
+	if len(url) == 0 {
+		return
+	}
+	i.PTranscripts = append(i.PTranscripts, &PTranscript{
+		URL:      url,
+		Type:     transcriptType,
+		Language: language,
+		Rel:      rel,
+	})
+}
+
+// AddPerson appends a podcast:person credit to the Item.
+func (i *Item) AddPerson(name, role, img, href string) {
+	if len(name) == 0 {
+		return
+	}
+	i.PPersons = append(i.PPersons, &PPerson{Name: name, Role: role, Img: img, Href: href})
+}
+
+// AddSoundbite appends a podcast:soundbite to the Item. Times are in seconds.
+func (i *Item) AddSoundbite(startTime, duration float64, title string) {
+	if duration <= 0 {
+		return
+	}
+	i.PSoundbites = append(i.PSoundbites, &PSoundbite{
+		StartTime: startTime,
+		Duration:  duration,
+		Title:     title,
+	})
+}
+
+func podcastGUID(feedURL string) string {
+	name := feedURL
+	if idx := strings.Index(name, "://"); idx >= 0 {
+		name = name[idx+3:]
+	}
+	name = strings.TrimRight(name, "/")
+
+	nsBytes, _ := hex.DecodeString(strings.ReplaceAll(podcastGUIDNamespace, "-", ""))
+
+	h := sha1.New()
+	h.Write(nsBytes)
+	h.Write([]byte(name))
+	sum := h.Sum(nil)
+
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
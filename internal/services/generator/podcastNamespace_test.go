@@ -0,0 +1,47 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPodcastGUID_MatchesSpecExample(t *testing.T) {
+	// Example taken from the podcast:guid section of the namespace spec.
+	want := "9b024349-ccf0-5f69-a609-6b82873eab3c"
+	for _, feedURL := range []string{"podnews.net/rss", "https://podnews.net/rss", "http://podnews.net/rss/"} {
+		if got := podcastGUID(feedURL); got != want {
+			t.Fatalf("podcastGUID(%q) = %s, want %s", feedURL, got, want)
+		}
+	}
+}
+
+func TestEncode_WritesPodcastNamespace(t *testing.T) {
+	p := New("title", "https://www.youtube.com/channel/abc", "description", nil)
+	p.AddGUID("https://www.youtube.com/channel/abc")
+	p.AddLocked(true, "")
+	p.AddMedium(MediumPodcast)
+	p.AddPerson("Host", "host", "", "")
+
+	item := Item{Title: "episode", Description: "desc"}
+	item.AddEnclosure("https://example.com/media/abc", M4A, 10)
+	item.AddChapters("https://example.com/chapters/abc", ChaptersJSON)
+	item.AddTranscript("https://example.com/transcript/abc", TranscriptVTT, "en", "captions")
+	if _, err := p.AddItem(item); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+
+	out := p.String()
+	for _, want := range []string{
+		`xmlns:podcast="https://podcastindex.org/namespace/1.0"`,
+		`<podcast:guid>`,
+		`<podcast:locked>yes</podcast:locked>`,
+		`<podcast:medium>podcast</podcast:medium>`,
+		`<podcast:person role="host">Host</podcast:person>`,
+		`<podcast:chapters url="https://example.com/chapters/abc" type="application/json+chapters"></podcast:chapters>`,
+		`<podcast:transcript url="https://example.com/transcript/abc" type="text/vtt" language="en" rel="captions"></podcast:transcript>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("encoded feed missing %s\n%s", want, out)
+		}
+	}
+}
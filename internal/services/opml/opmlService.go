@@ -0,0 +1,228 @@
+package opml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"ikoyhn/podcast-sponsorblock/internal/services/rss"
+	"ikoyhn/podcast-sponsorblock/internal/services/youtube"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/labstack/gommon/log"
+	"github.com/pkg/errors"
+)
+
+// Specifications: http://opml.org/spec2.opml
+
+type Opml struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    Head     `xml:"head"`
+	Body    Body     `xml:"body"`
+}
+
+type Head struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type Body struct {
+	Outlines []Outline `xml:"outline"`
+}
+
+type Outline struct {
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string    `xml:"htmlUrl,attr,omitempty"`
+	URL      string    `xml:"url,attr,omitempty"`
+	Outlines []Outline `xml:"outline"`
+}
+
+// FeedSource is a YouTube playlist or channel found in an imported file.
+type FeedSource struct {
+	Id   string
+	Type enum.PodcastType
+}
+
+type ImportResult struct {
+	Imported []string `json:"imported"`
+	Skipped  []string `json:"skipped"`
+	Failed   []string `json:"failed"`
+}
+
+// Export returns an OPML document with one outline per known podcast,
+// pointing at its /rss or /channel feed on host.
+func Export(host string) ([]byte, error) {
+	podcasts, err := database.GetAllPodcasts()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := Opml{
+		Version: "2.0",
+		Head: Head{
+			Title:       "Clean Cast",
+			DateCreated: time.Now().Format(time.RFC1123Z),
+		},
+		Body: Body{Outlines: []Outline{}},
+	}
+	for _, podcast := range podcasts {
+		podcastType := podcast.PodcastType()
+		htmlUrl := "https://www.youtube.com/playlist?list=" + podcast.Id
+		if podcastType == enum.CHANNEL {
+			htmlUrl = "https://www.youtube.com/channel/" + podcast.Id
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, Outline{
+			Text:    podcast.PodcastName,
+			Title:   podcast.PodcastName,
+			Type:    "rss",
+			XMLURL:  withBasicAuth(rss.FeedUrl(host, podcastType, podcast.Id)),
+			HTMLURL: htmlUrl,
+		})
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "opml.Export: xml.MarshalIndent returned error")
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// Import creates a podcast for every YouTube playlist or channel referenced
+// in the OPML document. Podcasts that already exist are skipped, and sources
+// that can't be found on YouTube are reported as failed instead of being
+// saved.
+func Import(data []byte) (*ImportResult, error) {
+	var doc Opml
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "opml.Import: invalid OPML")
+	}
+
+	result := &ImportResult{Imported: []string{}, Skipped: []string{}, Failed: []string{}}
+	seen := map[string]bool{}
+	for _, outline := range flattenOutlines(doc.Body.Outlines) {
+		source, ok := outlineSource(outline)
+		if !ok {
+			continue
+		}
+		if seen[source.Id] {
+			continue
+		}
+		seen[source.Id] = true
+
+		exists, err := database.PodcastExists(source.Id)
+		if err != nil {
+			log.Error(err)
+			result.Failed = append(result.Failed, source.Id)
+			continue
+		}
+		if exists {
+			result.Skipped = append(result.Skipped, source.Id)
+			continue
+		}
+
+		log.Infof("[OPML] Importing %s %s...", strings.ToLower(string(source.Type)), source.Id)
+		// The build date is left empty so the first feed request pulls in the
+		// episodes.
+		podcast := &models.Podcast{Id: source.Id, Type: string(source.Type)}
+		if !youtube.FillPodcastMetadata(podcast, source.Type == enum.PLAYLIST) {
+			log.Warnf("[OPML] Unable to find %s on YouTube", source.Id)
+			result.Failed = append(result.Failed, source.Id)
+			continue
+		}
+		if err := database.UpdatePodcast(podcast); err != nil {
+			log.Error(err)
+			result.Failed = append(result.Failed, source.Id)
+			continue
+		}
+		result.Imported = append(result.Imported, source.Id)
+	}
+	return result, nil
+}
+
+// ParseFeedSource extracts the playlist or channel from a YouTube URL, a
+// YouTube feed URL or a feed URL of another Clean Cast instance.
+func ParseFeedSource(rawUrl string) (FeedSource, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil || parsed.Host == "" {
+		return FeedSource{}, false
+	}
+	query := parsed.Query()
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	isYoutube := host == "youtube.com" || strings.HasSuffix(host, ".youtube.com")
+
+	var source FeedSource
+	switch {
+	case query.Get("playlist_id") != "":
+		source = FeedSource{Id: query.Get("playlist_id"), Type: enum.PLAYLIST}
+	case query.Get("channel_id") != "":
+		source = FeedSource{Id: query.Get("channel_id"), Type: enum.CHANNEL}
+	case isYoutube && query.Get("list") != "":
+		source = FeedSource{Id: query.Get("list"), Type: enum.PLAYLIST}
+	case len(segments) >= 2 && segments[len(segments)-2] == "channel":
+		source = FeedSource{Id: segments[len(segments)-1], Type: enum.CHANNEL}
+	case !isYoutube && len(segments) >= 2 && segments[len(segments)-2] == "rss":
+		source = FeedSource{Id: segments[len(segments)-1], Type: enum.PLAYLIST}
+	case isYoutube && strings.HasPrefix(segments[0], "@"):
+		channelId, err := youtube.ResolveChannelHandle(segments[0])
+		if err != nil {
+			log.Warnf("[OPML] Unable to resolve %s: %v", segments[0], err)
+			return FeedSource{}, false
+		}
+		source = FeedSource{Id: channelId, Type: enum.CHANNEL}
+	default:
+		return FeedSource{}, false
+	}
+
+	if source.Id == "" || !common.IsValidID(source.Id) {
+		return FeedSource{}, false
+	}
+	return source, true
+}
+
+func outlineSource(outline Outline) (FeedSource, bool) {
+	for _, candidate := range []string{outline.XMLURL, outline.HTMLURL, outline.URL} {
+		if candidate == "" {
+			continue
+		}
+		if source, ok := ParseFeedSource(candidate); ok {
+			return source, true
+		}
+	}
+	return FeedSource{}, false
+}
+
+func flattenOutlines(outlines []Outline) []Outline {
+	var flat []Outline
+	for _, outline := range outlines {
+		flat = append(flat, outline)
+		flat = append(flat, flattenOutlines(outline.Outlines)...)
+	}
+	return flat
+}
+
+// withBasicAuth adds the configured basic auth credentials to a feed URL,
+// since podcast apps can't be told about them any other way through OPML.
+func withBasicAuth(feedUrl string) string {
+	password := config.AppConfig.Authentication.BasicAuth.Password
+	if password == "" {
+		return feedUrl
+	}
+	parsed, err := url.Parse(feedUrl)
+	if err != nil {
+		return feedUrl
+	}
+	parsed.User = url.UserPassword(config.AppConfig.Authentication.BasicAuth.Username, password)
+	return parsed.String()
+}
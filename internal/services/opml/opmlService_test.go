@@ -0,0 +1,33 @@
+package opml
+
+import (
+	"testing"
+
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+)
+
+func TestParseFeedSource(t *testing.T) {
+	cases := []struct {
+		url  string
+		want FeedSource
+		ok   bool
+	}{
+		{"https://www.youtube.com/playlist?list=PLbh0Jamvptwfp_qc439PLuyKJ-tWUt222", FeedSource{"PLbh0Jamvptwfp_qc439PLuyKJ-tWUt222", enum.PLAYLIST}, true},
+		{"https://www.youtube.com/channel/UCoj1ZgGoSBoonNZqMsVUfAA", FeedSource{"UCoj1ZgGoSBoonNZqMsVUfAA", enum.CHANNEL}, true},
+		{"https://www.youtube.com/feeds/videos.xml?channel_id=UCoj1ZgGoSBoonNZqMsVUfAA", FeedSource{"UCoj1ZgGoSBoonNZqMsVUfAA", enum.CHANNEL}, true},
+		{"https://www.youtube.com/feeds/videos.xml?playlist_id=PLabc", FeedSource{"PLabc", enum.PLAYLIST}, true},
+		{"https://www.youtube.com/watch?v=abc&list=PLabc", FeedSource{"PLabc", enum.PLAYLIST}, true},
+		{"http://cleancast.local:8080/rss/PLabc?token=secret", FeedSource{"PLabc", enum.PLAYLIST}, true},
+		{"http://cleancast.local:8080/channel/UCabc", FeedSource{"UCabc", enum.CHANNEL}, true},
+		{"https://example.com/podcast.xml", FeedSource{}, false},
+		{"https://www.youtube.com/channel/..%2Fetc", FeedSource{}, false},
+		{"not a url", FeedSource{}, false},
+	}
+
+	for _, tc := range cases {
+		got, ok := ParseFeedSource(tc.url)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("ParseFeedSource(%q) = %+v, %v; want %+v, %v", tc.url, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package playlist
 
 import (
-	"ikoyhn/podcast-sponsorblock/internal/config"
 	"ikoyhn/podcast-sponsorblock/internal/database"
 	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/autodownload"
 	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"ikoyhn/podcast-sponsorblock/internal/services/downloader"
 	"ikoyhn/podcast-sponsorblock/internal/services/rss"
 	"ikoyhn/podcast-sponsorblock/internal/services/youtube"
 	"net/http"
@@ -15,40 +17,38 @@ import (
 	ytApi "google.golang.org/api/youtube/v3"
 )
 
-func BuildPlaylistRssFeed(youtubePlaylistId string, host string) []byte {
+func BuildPlaylistRssFeed(youtubePlaylistId string, params *models.RssRequestParams, host string) []byte {
 	log.Debug("[RSS FEED] Building rss feed for playlist...")
 	dbPodcast := database.GetPodcast(youtubePlaylistId)
 
-	shouldUpdate := true
-	if dbPodcast != nil && dbPodcast.LastBuildDate != "" {
-		dur, err := time.ParseDuration(config.AppConfig.Setup.PodcastRefreshInterval)
-		if err != nil {
-			panic("Invalid [podcast-refresh-interval] format. Use formats like '5m', '1h', '400s'.")
-		}
-		lastBuild, err := time.Parse(time.RFC1123, dbPodcast.LastBuildDate)
-		if err == nil && time.Since(lastBuild) < dur {
-			shouldUpdate = false
-			log.Infof("[YOUTUBE API] Skipping channel update, last build date within %v", dur)
+	if youtube.ShouldRefresh(dbPodcast) {
+		if youtube.GetChannelData(dbPodcast, youtubePlaylistId, true) != nil {
+			if getYoutubePlaylistData(youtubePlaylistId) > 0 {
+				autodownload.Run(youtubePlaylistId, enum.PLAYLIST)
+			}
+			downloader.EstimateMediaInfo(youtubePlaylistId)
 		}
-	}
-
-	if shouldUpdate {
-		dbPodcast = youtube.GetChannelData(dbPodcast, youtubePlaylistId, true)
-		getYoutubePlaylistData(youtubePlaylistId)
 		dbPodcast = database.GetPodcast(youtubePlaylistId)
 	}
+	if dbPodcast == nil {
+		log.Errorf("[RSS FEED] Unable to find podcast %s", youtubePlaylistId)
+		return nil
+	}
 
-	episodes, err := database.GetPodcastEpisodesByPodcastId(youtubePlaylistId, enum.PLAYLIST)
+	episodes, paging, err := rss.GetFeedEpisodes(*dbPodcast, enum.PLAYLIST, params)
 	if err != nil {
 		log.Error(err)
 		return nil
 	}
 
 	podcastRss := rss.BuildPodcast(*dbPodcast, episodes)
-	return rss.GenerateRssFeed(podcastRss, host, enum.PLAYLIST)
+	return rss.GenerateRssFeed(podcastRss, host, enum.PLAYLIST, params, paging)
 }
 
-func getYoutubePlaylistData(youtubePlaylistId string) {
+// getYoutubePlaylistData saves the playlist videos that are not stored yet and
+// returns how many new episodes were found.
+func getYoutubePlaylistData(youtubePlaylistId string) int {
+	newEpisodes := 0
 	continueRequestingPlaylistItems := true
 	pageToken := "first_call"
 	isPlaylistDescOrder := true
@@ -70,7 +70,7 @@ func getYoutubePlaylistData(youtubePlaylistId string) {
 		}
 		if response.HTTPStatusCode != http.StatusOK {
 			log.Errorf("YouTube API returned status code %s for Playlist: %s", strconv.Itoa(response.HTTPStatusCode), youtubePlaylistId)
-			return
+			return newEpisodes
 		}
 
 		if pageToken == "first_call" {
@@ -97,11 +97,12 @@ func getYoutubePlaylistData(youtubePlaylistId string) {
 				}
 			}
 		}
-		youtube.GetVideosAndValidate(missingVideoIds, enum.PLAYLIST, youtubePlaylistId)
+		newEpisodes += youtube.GetVideosAndValidate(missingVideoIds, enum.PLAYLIST, youtubePlaylistId)
 		if response.NextPageToken == "" {
 			continueRequestingPlaylistItems = false
 		}
 	}
+	return newEpisodes
 }
 
 func isPlaylistInDescOrder(items []*ytApi.PlaylistItem) bool {
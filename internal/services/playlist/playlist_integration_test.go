@@ -12,7 +12,7 @@ func TestGenerateRSSFromPlaylist_IT(t *testing.T) {
 	tests.SetupIntegration(t)
 
 	playlistID := "PLa7q8UDa6tvGRXE3-pdbiDQ-5_jRpqOkf"
-	rssBytes := BuildPlaylistRssFeed(playlistID, "https://example.com")
+	rssBytes := BuildPlaylistRssFeed(playlistID, nil, "https://example.com")
 	if len(rssBytes) == 0 {
 		t.Fatalf("no RSS generated for playlist %s", playlistID)
 	}
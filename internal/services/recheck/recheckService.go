@@ -0,0 +1,166 @@
+package recheck
+
+import (
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"ikoyhn/podcast-sponsorblock/internal/services/downloader"
+	"ikoyhn/podcast-sponsorblock/internal/services/ntfy"
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
+	"sync"
+	"time"
+
+	log "github.com/labstack/gommon/log"
+)
+
+// maxRecheckDelay caps the wait between two lookups of an old episode that
+// is still cached.
+const maxRecheckDelay = 7 * 24 * time.Hour
+
+// running keeps a slow run from overlapping with the next one.
+var running sync.Mutex
+
+// Run looks the SponsorBlock segments of recently published and cached
+// episodes up again, each once its delay since the last lookup has passed.
+// Cached episodes whose segments changed are cut again in the background.
+func Run() {
+	if !running.TryLock() {
+		return
+	}
+	defer running.Unlock()
+
+	interval := config.SponsorBlockRecheckInterval()
+	if interval <= 0 {
+		return
+	}
+	now := time.Now()
+	episodes, cached := candidates(now)
+
+	checked, recut := 0, 0
+	for _, episode := range episodes {
+		cache := database.GetSponsorSegmentCache(episode.YoutubeVideoId)
+		if cache != nil && now.Sub(cache.FetchedAt) < RecheckDelay(interval, now.Sub(episode.PublishedDate)) {
+			continue
+		}
+		previous := sponsorblock.CachedSegments(episode.YoutubeVideoId)
+		if err := sponsorblock.RefreshSegments(episode.YoutubeVideoId); err != nil {
+			log.Warnf("[RECHECK] Unable to look up %s: %v", episode.YoutubeVideoId, err)
+			continue
+		}
+		checked++
+		if cached[episode.YoutubeVideoId] && recutIfChanged(episode, previous) {
+			recut++
+		}
+	}
+	if checked > 0 {
+		log.Infof("[RECHECK] Looked up %d episodes, %d cut again", checked, recut)
+	}
+}
+
+// RecheckDelay returns how long after the last lookup the segments of an
+// episode of the given age are looked up again: the interval during its
+// first day, doubled for every day after, up to a week. Most segments are
+// submitted right after a video is published.
+func RecheckDelay(interval time.Duration, age time.Duration) time.Duration {
+	delay := interval
+	for days := age / (24 * time.Hour); days > 0 && delay < maxRecheckDelay; days-- {
+		delay *= 2
+	}
+	return min(delay, maxRecheckDelay)
+}
+
+// candidates returns the episodes published within the recheck days and the
+// cached ones, along with which of them are cached.
+func candidates(now time.Time) ([]models.PodcastEpisode, map[string]bool) {
+	cached := map[string]bool{}
+	for _, dir := range database.EpisodeDirs() {
+		files, err := storage.List(dir, "")
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			cached[common.TrimExtension(file.Name)] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	var episodes []models.PodcastEpisode
+	recent, err := database.GetEpisodesPublishedSince(now.AddDate(0, 0, -config.AppConfig.SponsorBlock.RecheckDays))
+	if err != nil {
+		log.Error(err)
+	}
+	for _, episode := range recent {
+		if !seen[episode.YoutubeVideoId] {
+			seen[episode.YoutubeVideoId] = true
+			episodes = append(episodes, episode)
+		}
+	}
+	for youtubeVideoId := range cached {
+		if seen[youtubeVideoId] {
+			continue
+		}
+		episode, err := database.GetEpisodeByVideoId(youtubeVideoId)
+		if err != nil {
+			continue
+		}
+		seen[youtubeVideoId] = true
+		episodes = append(episodes, *episode)
+	}
+	return episodes, cached
+}
+
+// recutIfChanged queues the cached files of an episode whose segments
+// changed to be cut again. The regular files are compared with the cut they
+// were made with, each variant with the segments of its own categories as
+// they were before the lookup. Each file keeps being served until its new
+// cut replaces it.
+func recutIfChanged(episode models.PodcastEpisode, previous []sponsorblock.SponsorBlockResponse) bool {
+	youtubeVideoId := episode.YoutubeVideoId
+	recut := false
+
+	history := database.GetEpisodePlaybackHistory(youtubeVideoId)
+	timeSkipped := sponsorblock.TotalSponsorTimeSkipped(youtubeVideoId)
+	if history.YoutubeVideoId != "" && sponsorblock.SegmentsChanged(history.TotalTimeSkipped, timeSkipped) {
+		log.Infof("[RECHECK] Segments of %s changed, %.0fs now cut instead of %.0fs", youtubeVideoId, timeSkipped, history.TotalTimeSkipped)
+		if err := database.UpdateEpisodeTimeSkipped(youtubeVideoId, timeSkipped); err != nil {
+			log.Error(err)
+		}
+		// Audio profiles are transcoded again once the audio is replaced.
+		for _, media := range []enum.MediaType{enum.AUDIO, enum.VIDEO} {
+			if database.FileExistsWithId(config.MediaDir(media), youtubeVideoId) {
+				downloader.RecutMedia(youtubeVideoId, media, "")
+			}
+		}
+		title := episode.EpisodeName
+		if title == "" {
+			title = youtubeVideoId
+		}
+		ntfy.SendNotification(fmt.Sprintf("%s got a better cut: %.0fs of segments removed, was %.0fs", title, timeSkipped, history.TotalTimeSkipped), "Clean Cast - Re-cut")
+		recut = true
+	}
+
+	// Without the segments from before there is nothing to compare with.
+	if previous == nil {
+		return recut
+	}
+	current := sponsorblock.CachedSegments(youtubeVideoId)
+	for _, key := range database.GetVariantCategories() {
+		categories, _ := sponsorblock.ParseVariantKey(key)
+		before, after := sponsorblock.TimeSkippedBy(previous, categories), sponsorblock.TimeSkippedBy(current, categories)
+		if !sponsorblock.SegmentsChanged(before, after) {
+			continue
+		}
+		for _, media := range []enum.MediaType{enum.AUDIO, enum.VIDEO} {
+			if database.FileExistsWithId(config.VariantDir(media, key), youtubeVideoId) {
+				log.Infof("[RECHECK] Segments of %s as %s changed, %.0fs now instead of %.0fs", youtubeVideoId, key, after, before)
+				downloader.RecutMedia(youtubeVideoId, media, key)
+				recut = true
+			}
+		}
+	}
+	return recut
+}
@@ -0,0 +1,26 @@
+package recheck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecheckDelay(t *testing.T) {
+	day := 24 * time.Hour
+	cases := []struct {
+		age  time.Duration
+		want time.Duration
+	}{
+		{0, time.Hour},
+		{23 * time.Hour, time.Hour},
+		{day, 2 * time.Hour},
+		{3*day + time.Hour, 8 * time.Hour},
+		{30 * day, maxRecheckDelay},
+		{-time.Hour, time.Hour},
+	}
+	for _, tc := range cases {
+		if got := RecheckDelay(time.Hour, tc.age); got != tc.want {
+			t.Errorf("RecheckDelay(1h, %s) = %s, want %s", tc.age, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package rss
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"net/url"
+)
+
+// MergeEpisodeFilter returns the filter stored on the podcast with every
+// expression given in the request taking its place.
+func MergeEpisodeFilter(stored models.EpisodeFilter, requested models.EpisodeFilter) models.EpisodeFilter {
+	if requested.IncludeTitle != "" {
+		stored.IncludeTitle = requested.IncludeTitle
+	}
+	if requested.ExcludeTitle != "" {
+		stored.ExcludeTitle = requested.ExcludeTitle
+	}
+	if requested.IncludeDescription != "" {
+		stored.IncludeDescription = requested.IncludeDescription
+	}
+	if requested.ExcludeDescription != "" {
+		stored.ExcludeDescription = requested.ExcludeDescription
+	}
+	return stored
+}
+
+// setFilterQuery adds the filter expressions given in the request to query.
+func setFilterQuery(query url.Values, filter models.EpisodeFilter) {
+	for key, value := range map[string]string{
+		"include_title":       filter.IncludeTitle,
+		"exclude_title":       filter.ExcludeTitle,
+		"include_description": filter.IncludeDescription,
+		"exclude_description": filter.ExcludeDescription,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package rss
+
+import (
+	"testing"
+
+	"ikoyhn/podcast-sponsorblock/internal/models"
+)
+
+func TestEpisodeMatcher(t *testing.T) {
+	filter := MergeEpisodeFilter(
+		models.EpisodeFilter{IncludeTitle: `episode \d+`, ExcludeTitle: "clip"},
+		models.EpisodeFilter{ExcludeDescription: "#shorts|trailer"},
+	)
+	matcher, err := models.CompileEpisodeFilter(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		title       string
+		description string
+		want        bool
+	}{
+		{"Episode 12: Full interview", "", true},
+		{"Episode 12 - best clip", "", false},
+		{"Live stream VOD", "", false},
+		{"Episode 13", "Season TRAILER", false},
+	}
+	for _, tc := range cases {
+		episode := models.PodcastEpisode{EpisodeName: tc.title, EpisodeDescription: tc.description}
+		if got := matcher.Matches(episode); got != tc.want {
+			t.Errorf("Matches(%q, %q) = %v, want %v", tc.title, tc.description, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package rss
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/generator"
+	"slices"
+)
+
+// FeedPaging describes where a feed document sits in an RFC 5005 paged and
+// archived feed.
+//
+// Page 0 is the subscription document with the newest episodes. Archive
+// pages are numbered from the oldest episodes up and only ever hold full
+// pages, so their contents never change once published.
+type FeedPaging struct {
+	Page     int
+	Archives int
+}
+
+// feedScanBatchSize is how many episodes are read at once when paging a feed
+// with an episode filter.
+const feedScanBatchSize = 500
+
+// GetFeedEpisodes loads the episodes for the requested feed page, newest
+// first. Only the episodes listed in the feed are paged and counted, so
+// filtered out episodes don't leave pages short. When feed-page-size is not
+// configured every listed episode is returned together with a nil
+// FeedPaging.
+func GetFeedEpisodes(podcast models.Podcast, podcastType enum.PodcastType, params *models.RssRequestParams) ([]models.PodcastEpisode, *FeedPaging, error) {
+	filter := podcast.Filter
+	page := 0
+	if params != nil {
+		filter = MergeEpisodeFilter(filter, params.Filter)
+		if params.Page != nil {
+			page = *params.Page
+		}
+	}
+	// An invalid filter is ignored, GenerateRssFeed reports it.
+	matcher, _ := models.CompileEpisodeFilter(filter)
+
+	pageSize := config.AppConfig.Setup.FeedPageSize
+	if pageSize <= 0 {
+		episodes, err := database.GetPodcastEpisodesByPodcastId(podcast.Id, podcastType)
+		if err != nil {
+			return nil, nil, err
+		}
+		listed := make([]models.PodcastEpisode, 0, len(episodes))
+		for _, episode := range episodes {
+			if IsListed(episode, matcher) {
+				listed = append(listed, episode)
+			}
+		}
+		return listed, nil, nil
+	}
+	if !matcher.IsEmpty() {
+		return scanFeedPage(podcast.Id, podcastType, matcher, page, pageSize)
+	}
+
+	count, err := database.CountListedEpisodes(podcast.Id, podcastType)
+	if err != nil {
+		return nil, nil, err
+	}
+	paging := &FeedPaging{Page: page, Archives: int(count) / pageSize}
+	switch {
+	case page == 0:
+		episodes, err := database.GetListedEpisodes(podcast.Id, podcastType, false, 0, pageSize)
+		return episodes, paging, err
+	case page > paging.Archives:
+		return []models.PodcastEpisode{}, paging, nil
+	default:
+		// Archive pages count from the oldest episode.
+		episodes, err := database.GetListedEpisodes(podcast.Id, podcastType, true, (page-1)*pageSize, pageSize)
+		slices.Reverse(episodes)
+		return episodes, paging, err
+	}
+}
+
+// scanFeedPage pages a feed with an episode filter, whose expressions can't
+// be matched in SQL. The episodes are read in batches from the oldest one,
+// keeping only those of the requested page that pass the filter.
+func scanFeedPage(podcastId string, podcastType enum.PodcastType, matcher *models.EpisodeMatcher, page int, pageSize int) ([]models.PodcastEpisode, *FeedPaging, error) {
+	var window []models.PodcastEpisode
+	listed := 0
+	for offset := 0; ; offset += feedScanBatchSize {
+		batch, err := database.GetListedEpisodes(podcastId, podcastType, true, offset, feedScanBatchSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, episode := range batch {
+			if !matcher.Matches(episode) {
+				continue
+			}
+			if page == 0 || listed/pageSize == page-1 {
+				window = append(window, episode)
+			}
+			listed++
+		}
+		// The subscription page holds the newest episodes read so far.
+		if page == 0 && len(window) > pageSize {
+			window = slices.Clone(window[len(window)-pageSize:])
+		}
+		if len(batch) < feedScanBatchSize {
+			break
+		}
+	}
+
+	paging := &FeedPaging{Page: page, Archives: listed / pageSize}
+	if page > paging.Archives {
+		return []models.PodcastEpisode{}, paging, nil
+	}
+	slices.Reverse(window)
+	return window, paging, nil
+}
+
+// addPagingLinks adds the RFC 5005 navigation links. Links named after both
+// paged feeds (next/previous) and archived feeds (prev-archive/next-archive)
+// are written so clients supporting either can walk back through the feed.
+func addPagingLinks(ytPodcast *generator.Podcast, paging *FeedPaging, pageUrl func(page int) string, linkType string) {
+	if paging == nil {
+		return
+	}
+
+	if paging.Page == 0 {
+		if paging.Archives > 0 {
+			ytPodcast.AddAtomLink(pageUrl(paging.Archives), "next", linkType)
+			ytPodcast.AddAtomLink(pageUrl(paging.Archives), "prev-archive", linkType)
+		}
+		return
+	}
+
+	ytPodcast.MarkArchive()
+	ytPodcast.AddAtomLink(pageUrl(0), "current", linkType)
+	ytPodcast.AddAtomLink(pageUrl(0), "first", linkType)
+	if paging.Page > 1 {
+		ytPodcast.AddAtomLink(pageUrl(paging.Page-1), "next", linkType)
+		ytPodcast.AddAtomLink(pageUrl(paging.Page-1), "prev-archive", linkType)
+	}
+	if paging.Page < paging.Archives {
+		ytPodcast.AddAtomLink(pageUrl(paging.Page+1), "previous", linkType)
+		ytPodcast.AddAtomLink(pageUrl(paging.Page+1), "next-archive", linkType)
+	} else {
+		ytPodcast.AddAtomLink(pageUrl(0), "previous", linkType)
+	}
+}
@@ -0,0 +1,78 @@
+package rss
+
+import (
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/enum"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"path"
+	"testing"
+	"time"
+)
+
+func setupPagedFeed(t *testing.T) models.Podcast {
+	t.Helper()
+	tmpDir := t.TempDir()
+	config.AppConfig = &config.Config{}
+	config.AppConfig.Setup.ConfigDir = tmpDir
+	config.AppConfig.Setup.DbFile = path.Join(tmpDir, "test.db")
+	config.AppConfig.Setup.FeedPageSize = 2
+	database.SetupDatabase()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var episodes []models.PodcastEpisode
+	for i := 0; i < 7; i++ {
+		name := fmt.Sprintf("Episode %d", i)
+		if i%3 == 1 {
+			name = fmt.Sprintf("Clip %d", i)
+		}
+		episodes = append(episodes, models.PodcastEpisode{
+			YoutubeVideoId: fmt.Sprintf("video%d", i),
+			EpisodeName:    name,
+			PodcastId:      "playlist1",
+			Type:           string(enum.PLAYLIST),
+			PublishedDate:  base.Add(time.Duration(i) * 24 * time.Hour),
+		})
+	}
+	episodes[6].EpisodeName = "Private video"
+	database.SavePlaylistEpisodes(episodes)
+	return models.Podcast{Id: "playlist1"}
+}
+
+func feedPage(t *testing.T, podcast models.Podcast, page int) ([]string, *FeedPaging) {
+	t.Helper()
+	episodes, paging, err := GetFeedEpisodes(podcast, enum.PLAYLIST, &models.RssRequestParams{Page: &page})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, episode := range episodes {
+		ids = append(ids, episode.YoutubeVideoId)
+	}
+	return ids, paging
+}
+
+func TestGetFeedEpisodes_PagesListedEpisodes(t *testing.T) {
+	podcast := setupPagedFeed(t)
+
+	ids, paging := feedPage(t, podcast, 0)
+	if paging.Archives != 3 || fmt.Sprint(ids) != "[video5 video4]" {
+		t.Fatalf("unexpected subscription page %v, paging %+v", ids, paging)
+	}
+	if ids, _ = feedPage(t, podcast, 2); fmt.Sprint(ids) != "[video3 video2]" {
+		t.Fatalf("unexpected archive page %v", ids)
+	}
+
+	podcast.Filter.ExcludeTitle = "^clip"
+	ids, paging = feedPage(t, podcast, 0)
+	if paging.Archives != 2 || fmt.Sprint(ids) != "[video5 video3]" {
+		t.Fatalf("unexpected filtered subscription page %v, paging %+v", ids, paging)
+	}
+	if ids, _ = feedPage(t, podcast, 1); fmt.Sprint(ids) != "[video2 video0]" {
+		t.Fatalf("unexpected filtered archive page %v", ids)
+	}
+	if ids, _ = feedPage(t, podcast, 3); len(ids) != 0 {
+		t.Fatalf("expected no episodes past the last archive, got %v", ids)
+	}
+}
@@ -0,0 +1,75 @@
+package rss
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// FeedValidators returns the ETag and Last-Modified values for a podcast
+// feed, computed only from what is stored in the database so they can be
+// checked without refreshing from YouTube or encoding the feed.
+//
+// The query is part of the ETag because params such as format change the
+// response body. The time an episode of the podcast last changed is part of
+// both, so re-cuts, processing and transcripts show up without a refresh.
+// It returns false when the podcast is not known yet.
+func FeedValidators(podcastId string, query url.Values) (string, time.Time, bool) {
+	podcast := database.GetPodcast(podcastId)
+	if podcast == nil {
+		return "", time.Time{}, false
+	}
+	latestEpisode, _ := database.GetLatestEpisode(podcastId)
+	return feedValidators(podcast, latestEpisode, database.GetEpisodesUpdatedAt(podcastId), query)
+}
+
+func feedValidators(podcast *models.Podcast, latestEpisode *models.PodcastEpisode, episodesUpdatedAt time.Time, query url.Values) (string, time.Time, bool) {
+	lastModified, err := time.Parse(time.RFC1123, podcast.LastBuildDate)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	h := sha256.New()
+	h.Write([]byte(podcast.Id))
+	h.Write([]byte(podcast.LastBuildDate))
+	h.Write([]byte(fmt.Sprintf("%+v", podcast.Filter)))
+	h.Write([]byte(podcast.AudioProfile))
+	h.Write([]byte(fmt.Sprintf("%+v", podcast.Processing)))
+	h.Write([]byte(podcast.SponsorBlockCategories))
+	h.Write([]byte(podcast.SponsorBlockMode))
+	if latestEpisode != nil {
+		h.Write([]byte(latestEpisode.YoutubeVideoId))
+		h.Write([]byte(strconv.FormatInt(latestEpisode.PublishedDate.Unix(), 10)))
+		if latestEpisode.PublishedDate.After(lastModified) {
+			lastModified = latestEpisode.PublishedDate
+		}
+	}
+	h.Write([]byte(strconv.FormatInt(episodesUpdatedAt.UnixNano(), 10)))
+	if episodesUpdatedAt.After(lastModified) {
+		lastModified = episodesUpdatedAt
+	}
+	h.Write([]byte(strconv.FormatInt(podcast.UpdatedAt.UnixNano(), 10)))
+	if podcast.UpdatedAt.After(lastModified) {
+		lastModified = podcast.UpdatedAt
+	}
+
+	query = cloneQuery(query)
+	query.Del("token")
+	h.Write([]byte(query.Encode()))
+
+	etag := `"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+	return etag, lastModified.UTC().Truncate(time.Second), true
+}
+
+func cloneQuery(query url.Values) url.Values {
+	clone := url.Values{}
+	for key, values := range query {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
@@ -4,24 +4,38 @@ import (
 	"encoding/xml"
 	"fmt"
 	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
 	"ikoyhn/podcast-sponsorblock/internal/enum"
 	"ikoyhn/podcast-sponsorblock/internal/models"
+	"ikoyhn/podcast-sponsorblock/internal/services/chapters"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"ikoyhn/podcast-sponsorblock/internal/services/downloader"
 	"ikoyhn/podcast-sponsorblock/internal/services/generator"
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"ikoyhn/podcast-sponsorblock/internal/services/storage"
 	"net/url"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/labstack/gommon/log"
 )
 
-func GenerateRssFeed(podcast models.Podcast, host string, podcastType enum.PodcastType) []byte {
+func GenerateRssFeed(podcast models.Podcast, host string, podcastType enum.PodcastType, params *models.RssRequestParams, paging *FeedPaging) []byte {
 	log.Info("[RSS FEED] Generating RSS Feed...")
+	if params == nil {
+		params = &models.RssRequestParams{}
+	}
 
 	podcastLink := "https://www.youtube.com/playlist?list=" + podcast.Id
+	feedPath := "/rss/" + podcast.Id
 
 	if podcastType == enum.CHANNEL {
 		podcastLink = "https://www.youtube.com/channel/" + podcast.Id
+		feedPath = "/channel/" + podcast.Id
 	}
 
 	now := time.Now()
@@ -30,21 +44,126 @@ func GenerateRssFeed(podcast models.Podcast, host string, podcastType enum.Podca
 	ytPodcast.AddCategory(podcast.Category, []string{""})
 	ytPodcast.Docs = "http://www.rssboard.org/rss-specification"
 	ytPodcast.IAuthor = podcast.ArtistName
+	ytPodcast.AddAtomLink(appUrl(host, feedPath, feedQuery(params)), "self", feedContentType(params.Format))
+	addPagingLinks(&ytPodcast, paging, func(page int) string {
+		query := feedQuery(params)
+		query.Del("page")
+		if page > 0 {
+			query.Set("page", strconv.Itoa(page))
+		}
+		return appUrl(host, feedPath, query)
+	}, feedContentType(params.Format))
+
+	// Podcasting 2.0 namespace. The guid is derived from the YouTube link so
+	// it stays the same no matter which host the feed is requested through.
+	ytPodcast.AddGUID(podcastLink)
+	ytPodcast.AddLocked(config.AppConfig.Setup.FeedLocked, config.AppConfig.Setup.FeedOwnerEmail)
+	if params.Media == enum.VIDEO {
+		ytPodcast.AddMedium(generator.MediumVideo)
+	} else {
+		ytPodcast.AddMedium(generator.MediumPodcast)
+	}
+	ytPodcast.AddPerson(podcast.ArtistName, "host", podcast.ImageUrl, podcastLink)
+	for _, fundingUrl := range findFundingLinks(podcast.Description) {
+		ytPodcast.AddFunding(fundingUrl, "Support the show")
+	}
+
+	matcher, err := models.CompileEpisodeFilter(MergeEpisodeFilter(podcast.Filter, params.Filter))
+	if err != nil {
+		log.Errorf("[RSS FEED] Ignoring episode filter of %s: %v", podcast.Id, err)
+	}
+
+	transcripts := downloader.SubtitlesAvailable()
+	transcriptLanguage := config.AppConfig.Ytdlp.SubtitleLanguage
+
+	// Other SponsorBlock categories than the podcast's are cut into a
+	// variant of each episode, linked with ?sb=. Marking the segments instead
+	// is a variant as well, linked with ?sb_mode=mark unless the podcast
+	// marks them anyway.
+	variant := sponsorblock.VariantKey(&podcast, params.SponsorBlock, params.SponsorBlockMode)
+	variantCategories, mark := sponsorblock.ParseVariantKey(variant)
+	podcastMarks := sponsorblock.PodcastMode(&podcast) == sponsorblock.MarkMode
+	variantQuery := func(query url.Values) url.Values {
+		if variant == "" && !podcastMarks {
+			return query
+		}
+		if query == nil {
+			query = url.Values{}
+		}
+		if variant != "" {
+			query.Set("sb", sponsorblock.CategoryKey(variantCategories))
+		}
+		switch {
+		case mark && !podcastMarks:
+			query.Set("sb_mode", sponsorblock.MarkMode)
+		case !mark && podcastMarks:
+			query.Set("sb_mode", sponsorblock.CutMode)
+		}
+		return query
+	}
+
+	var videoSizes map[string]int64
+	if params.Media == enum.VIDEO {
+		videoSizes = cachedFileSizes(config.AppConfig.Setup.VideoDir)
+		if variant != "" {
+			videoSizes = cachedFileSizes(config.VariantDir(enum.VIDEO, variant))
+		}
+	}
+	var variantSizes map[string]int64
+	if params.Media == enum.AUDIO && variant != "" {
+		variantSizes = cachedFileSizes(config.VariantDir(enum.AUDIO, variant))
+	}
+	// Videos and variants are served as downloaded, only the audio is
+	// processed.
+	processing := podcast.Processing
+	if params.Media == enum.VIDEO || variant != "" {
+		processing = models.AudioProcessing{}
+	}
+	var processed map[string]*models.ProcessedAudio
+	if processing.Enabled() {
+		videoIds := make([]string, 0, len(podcast.PodcastEpisodes))
+		for _, episode := range podcast.PodcastEpisodes {
+			videoIds = append(videoIds, episode.YoutubeVideoId)
+		}
+		processed = database.GetProcessedAudios(videoIds)
+	}
+	profileName, profile, useProfile := feedAudioProfile(podcast, params)
+	useProfile = useProfile && variant == ""
+	var profileSizes map[string]int64
+	if useProfile {
+		profileSizes = cachedFileSizes(config.ProfileDir(profileName))
+	}
 
 	if podcast.PodcastEpisodes != nil {
 		for _, podcastEpisode := range podcast.PodcastEpisodes {
-			if (podcastEpisode.Type == "CHANNEL" && podcastEpisode.Duration.Seconds() < 120) || podcastEpisode.EpisodeName == "Private video" || podcastEpisode.EpisodeDescription == "This video is private." {
+			if !IsListed(podcastEpisode, matcher) {
 				continue
 			}
-			mediaUrl := host + "/media/" + podcastEpisode.YoutubeVideoId
-
-			if config.AppConfig.Authentication.Token != "" {
-				mediaUrl = mediaUrl + "?token=" + config.AppConfig.Authentication.Token
-			}
+			mediaUrl := appUrl(host, "/media/"+podcastEpisode.YoutubeVideoId, nil)
 			enclosure := generator.Enclosure{
 				URL:    mediaUrl,
-				Length: 0,
-				Type:   generator.M4A,
+				Length: enclosureLength(podcastEpisode),
+				Type:   generator.EnclosureTypeFromExtension(podcastEpisode.FileExtension),
+			}
+			if variant != "" {
+				enclosure.URL = appUrl(host, "/media/"+podcastEpisode.YoutubeVideoId, variantQuery(nil))
+				if size, ok := variantSizes[podcastEpisode.YoutubeVideoId]; ok {
+					enclosure.Length = size
+				}
+			}
+			if useProfile {
+				enclosure = generator.Enclosure{
+					URL:    appUrl(host, "/media/"+podcastEpisode.YoutubeVideoId, url.Values{"profile": {profileName}}),
+					Length: profileEnclosureLength(podcastEpisode, profile, profileSizes[podcastEpisode.YoutubeVideoId]),
+					Type:   generator.EnclosureTypeFromExtension(downloader.ProfileExtension(profile)),
+				}
+			}
+			if params.Media == enum.VIDEO {
+				enclosure = generator.Enclosure{
+					URL:    appUrl(host, "/video/"+podcastEpisode.YoutubeVideoId, variantQuery(nil)),
+					Length: videoEnclosureLength(podcastEpisode, videoSizes),
+					Type:   generator.MP4,
+				}
 			}
 
 			var builder strings.Builder
@@ -54,7 +173,7 @@ func GenerateRssFeed(podcast models.Podcast, host string, podcastType enum.Podca
 			podcastItem := generator.Item{
 				Title:       podcastEpisode.EpisodeName,
 				Description: escapedDescription,
-				IDuration:   fmt.Sprintf("%d", int(podcastEpisode.Duration.Seconds())),
+				IDuration:   fmt.Sprintf("%d", int(episodeDuration(podcastEpisode, processing, processed[podcastEpisode.YoutubeVideoId]))),
 				GUID: struct {
 					Value       string `xml:",chardata"`
 					IsPermaLink bool   `xml:"isPermaLink,attr"`
@@ -62,6 +181,7 @@ func GenerateRssFeed(podcast models.Podcast, host string, podcastType enum.Podca
 					Value:       podcastEpisode.YoutubeVideoId,
 					IsPermaLink: false,
 				},
+				Link:      "https://www.youtube.com/watch?v=" + podcastEpisode.YoutubeVideoId,
 				Enclosure: &enclosure,
 				PubDate:   &podcastEpisode.PublishedDate,
 			}
@@ -75,11 +195,185 @@ func GenerateRssFeed(podcast models.Podcast, host string, podcastType enum.Podca
 				}
 			}
 
+			// Marked segments are chapters of their own.
+			if mark || chapters.HasChapters(podcastEpisode.EpisodeDescription) {
+				podcastItem.AddChapters(appUrl(host, "/chapters/"+podcastEpisode.YoutubeVideoId, variantQuery(nil)), generator.ChaptersJSON)
+			}
+
+			if transcripts[podcastEpisode.YoutubeVideoId] {
+				transcriptPath := "/transcript/" + podcastEpisode.YoutubeVideoId
+				podcastItem.AddTranscript(appUrl(host, transcriptPath, variantQuery(nil)), generator.TranscriptVTT, transcriptLanguage, "captions")
+				podcastItem.AddTranscript(appUrl(host, transcriptPath, variantQuery(url.Values{"format": {"srt"}})), generator.TranscriptSRT, transcriptLanguage, "captions")
+			}
+
 			ytPodcast.AddItem(podcastItem)
 		}
 	}
 
-	return ytPodcast.Bytes()
+	switch params.Format {
+	case enum.ATOM:
+		return ytPodcast.AtomBytes()
+	case enum.JSON_FEED:
+		return ytPodcast.JSONFeedBytes()
+	default:
+		return ytPodcast.Bytes()
+	}
+}
+
+// IsListed reports whether an episode shows up in the feed: private videos
+// and channel videos under two minutes are always left out, the rest has to
+// pass the episode filter.
+func IsListed(episode models.PodcastEpisode, matcher *models.EpisodeMatcher) bool {
+	if (episode.Type == "CHANNEL" && episode.Duration.Seconds() < 120) || episode.EpisodeName == "Private video" || episode.EpisodeDescription == "This video is private." {
+		return false
+	}
+	return matcher.Matches(episode)
+}
+
+// FeedContentType returns the Content-Type header value for a feed format.
+func FeedContentType(format enum.FeedFormat) string {
+	return feedContentType(format) + "; charset=utf-8"
+}
+
+func feedContentType(format enum.FeedFormat) string {
+	switch format {
+	case enum.ATOM:
+		return generator.AtomContentType
+	case enum.JSON_FEED:
+		return generator.JSONFeedContentType
+	default:
+		return "application/rss+xml"
+	}
+}
+
+// feedQuery returns the query parameters that reproduce the requested feed.
+func feedQuery(params *models.RssRequestParams) url.Values {
+	query := url.Values{}
+	if params.Format != "" && params.Format != enum.RSS {
+		query.Set("format", string(params.Format))
+	}
+	if params.Limit != nil {
+		query.Set("limit", strconv.Itoa(*params.Limit))
+	}
+	if params.Date != nil {
+		query.Set("date", params.Date.Format("01-02-2006"))
+	}
+	if params.Page != nil && *params.Page > 0 {
+		query.Set("page", strconv.Itoa(*params.Page))
+	}
+	if params.Media == enum.VIDEO {
+		query.Set("media", string(params.Media))
+	}
+	if params.Profile != "" {
+		query.Set("profile", params.Profile)
+	}
+	if params.SponsorBlock != "" {
+		query.Set("sb", params.SponsorBlock)
+	}
+	if params.SponsorBlockMode != "" {
+		query.Set("sb_mode", params.SponsorBlockMode)
+	}
+	setFilterQuery(query, params.Filter)
+	return query
+}
+
+// feedAudioProfile returns the audio profile the enclosures of an audio feed
+// use: the one requested with ?profile=, else the one stored on the podcast.
+// Profiles that are no longer configured are ignored.
+func feedAudioProfile(podcast models.Podcast, params *models.RssRequestParams) (string, config.AudioProfile, bool) {
+	if params.Media == enum.VIDEO {
+		return "", config.AudioProfile{}, false
+	}
+	name := params.Profile
+	if name == "" {
+		name = podcast.AudioProfile
+	}
+	profile, ok := config.AppConfig.AudioProfiles[name]
+	return name, profile, ok
+}
+
+// episodeDuration returns the length in seconds of the audio served for an
+// episode. Processed audio uses its measured length; audio that still has to
+// be processed is estimated from the tempo.
+func episodeDuration(episode models.PodcastEpisode, processing models.AudioProcessing, processed *models.ProcessedAudio) float64 {
+	if !processing.Enabled() {
+		return episode.Duration.Seconds()
+	}
+	if processed != nil && processed.Duration > 0 && processed.Settings().Speed() == processing.Speed() && processed.RemoveSilence == processing.RemoveSilence {
+		return processed.Duration
+	}
+	return episode.Duration.Seconds() / processing.Speed()
+}
+
+// defaultAudioBytesPerSecond matches YouTube's 128 kbit/s m4a audio stream,
+// the format downloads are requested in.
+const defaultAudioBytesPerSecond = 128 * 1000 / 8
+
+// enclosureLength returns the stored size of the episode audio, or an
+// estimate based on its duration when nothing has been stored yet.
+func enclosureLength(episode models.PodcastEpisode) int64 {
+	if episode.FileSize > 0 {
+		return episode.FileSize
+	}
+	return int64(episode.Duration.Seconds()) * defaultAudioBytesPerSecond
+}
+
+// profileEnclosureLength returns the size of the transcoded episode, or an
+// estimate based on the profile bitrate when it hasn't been transcoded yet.
+func profileEnclosureLength(episode models.PodcastEpisode, profile config.AudioProfile, cachedSize int64) int64 {
+	if cachedSize > 0 {
+		return cachedSize
+	}
+	bytesPerSecond := int64(defaultAudioBytesPerSecond)
+	if bitrate := parseBitrate(profile.Bitrate); bitrate > 0 {
+		bytesPerSecond = bitrate / 8
+	}
+	return int64(episode.Duration.Seconds()) * bytesPerSecond
+}
+
+// parseBitrate reads an ffmpeg bitrate such as "64k" or "1.5M" in bits per
+// second. It returns 0 when the value can't be read.
+func parseBitrate(value string) int64 {
+	value = strings.TrimSpace(strings.ToLower(value))
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(value, "k"):
+		multiplier = 1000
+	case strings.HasSuffix(value, "m"):
+		multiplier = 1000 * 1000
+	}
+	n, err := strconv.ParseFloat(strings.TrimRight(value, "km"), 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return int64(n * multiplier)
+}
+
+// defaultVideoBytesPerSecond is a rough bitrate of a 720p MP4 download with
+// its audio, used until the video has been downloaded.
+const defaultVideoBytesPerSecond = 2500 * 1000 / 8
+
+// videoEnclosureLength returns the size of the cached video of the episode,
+// or an estimate based on its duration.
+func videoEnclosureLength(episode models.PodcastEpisode, cachedSizes map[string]int64) int64 {
+	if size, ok := cachedSizes[episode.YoutubeVideoId]; ok {
+		return size
+	}
+	return int64(episode.Duration.Seconds()) * defaultVideoBytesPerSecond
+}
+
+// cachedFileSizes returns the size of every downloaded file in dir keyed by
+// YouTube video ID, so a feed doesn't have to scan the directory per episode.
+func cachedFileSizes(dir string) map[string]int64 {
+	sizes := map[string]int64{}
+	files, err := storage.List(dir, "")
+	if err != nil {
+		return sizes
+	}
+	for _, file := range files {
+		sizes[common.TrimExtension(file.Name)] = file.Size
+	}
+	return sizes
 }
 
 func BuildPodcast(podcast models.Podcast, allItems []models.PodcastEpisode) models.Podcast {
@@ -87,6 +381,58 @@ func BuildPodcast(podcast models.Podcast, allItems []models.PodcastEpisode) mode
 	return podcast
 }
 
+// FeedUrl returns the URL of a podcast feed on this app, with the auth token
+// when one is configured.
+func FeedUrl(host string, podcastType enum.PodcastType, podcastId string) string {
+	if podcastType == enum.CHANNEL {
+		return appUrl(host, "/channel/"+podcastId, nil)
+	}
+	return appUrl(host, "/rss/"+podcastId, nil)
+}
+
+// appUrl builds an absolute URL to an endpoint of this app, adding the auth
+// token when one is configured.
+func appUrl(host, path string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	if config.AppConfig.Authentication.Token != "" {
+		query.Set("token", config.AppConfig.Authentication.Token)
+	}
+	if len(query) == 0 {
+		return host + path
+	}
+	return host + path + "?" + query.Encode()
+}
+
+var fundingHosts = []string{
+	"patreon.com",
+	"ko-fi.com",
+	"buymeacoffee.com",
+	"paypal.me",
+	"liberapay.com",
+	"github.com/sponsors",
+}
+
+var linkRegex = regexp.MustCompile(`https?://[^\s"'<>()]+`)
+
+// findFundingLinks returns the donation/membership links found in a channel
+// description, in the order they appear and without duplicates.
+func findFundingLinks(description string) []string {
+	var links []string
+	for _, link := range linkRegex.FindAllString(description, -1) {
+		link = strings.TrimRight(link, ".,;:!?")
+		lower := strings.ToLower(link)
+		for _, host := range fundingHosts {
+			if strings.Contains(lower, host) && !slices.Contains(links, link) {
+				links = append(links, link)
+				break
+			}
+		}
+	}
+	return links
+}
+
 func transformArtworkURL(artworkURL string, newHeight int, newWidth int) string {
 	parsedURL, err := url.Parse(artworkURL)
 	if err != nil {
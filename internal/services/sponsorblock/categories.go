@@ -0,0 +1,140 @@
+package sponsorblock
+
+import (
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"slices"
+	"strings"
+)
+
+// ParseCategories parses a comma separated list of categories, e.g. the
+// ?sb= of a feed, into a sorted list without duplicates, nil for an empty
+// list. Categories that aren't looked up are rejected.
+func ParseCategories(value string) ([]string, error) {
+	var categories []string
+	for _, category := range strings.Split(value, ",") {
+		category = strings.TrimSpace(category)
+		if category == "" {
+			continue
+		}
+		if !slices.Contains(fetchedCategories, category) {
+			return nil, fmt.Errorf("unknown SponsorBlock category %q, expected one of %s", category, strings.Join(fetchedCategories, ", "))
+		}
+		categories = append(categories, category)
+	}
+	slices.Sort(categories)
+	return slices.Compact(categories), nil
+}
+
+// CategoryKey returns the form a set of categories is stored, cached and
+// linked with.
+func CategoryKey(categories []string) string {
+	sorted := slices.Clone(categories)
+	slices.Sort(sorted)
+	return strings.Join(slices.Compact(sorted), ",")
+}
+
+// PodcastCategories returns the categories cut from the episodes of a
+// podcast, the configured ones unless the podcast has its own.
+func PodcastCategories(podcast *models.Podcast) []string {
+	if podcast != nil && podcast.SponsorBlockCategories != "" {
+		return strings.Split(podcast.SponsorBlockCategories, ",")
+	}
+	return getCategories()
+}
+
+// Categories returns the categories cut from a video, those of its podcast.
+func Categories(youtubeVideoId string) []string {
+	return PodcastCategories(episodePodcast(youtubeVideoId))
+}
+
+// episodePodcast returns the podcast of a video, nil when it isn't known.
+func episodePodcast(youtubeVideoId string) *models.Podcast {
+	episode, err := database.GetEpisodeByVideoId(youtubeVideoId)
+	if err != nil {
+		return nil
+	}
+	return database.GetPodcast(episode.PodcastId)
+}
+
+// MarkMode is the ?sb_mode= and podcast setting that keeps the segments in
+// the audio and marks them with chapters instead of cutting them. CutMode
+// cuts them, the default.
+const (
+	MarkMode = "mark"
+	CutMode  = "cut"
+)
+
+// PodcastMode returns whether the segments of a podcast's episodes are cut
+// or marked.
+func PodcastMode(podcast *models.Podcast) string {
+	if podcast != nil && podcast.SponsorBlockMode == MarkMode {
+		return MarkMode
+	}
+	return CutMode
+}
+
+// VariantKey returns the key of the variant of a podcast's episodes with the
+// segments of the given categories cut or marked, or "" when they are cut
+// with the podcast's own categories and the regular files apply. Empty
+// categories or mode stand for the podcast's.
+func VariantKey(podcast *models.Podcast, categories string, mode string) string {
+	if mode == "" {
+		mode = PodcastMode(podcast)
+	}
+	if mode == MarkMode {
+		if categories == "" {
+			categories = CategoryKey(PodcastCategories(podcast))
+		}
+		return MarkKey(categories)
+	}
+	if categories == "" || categories == CategoryKey(PodcastCategories(podcast)) {
+		return ""
+	}
+	return categories
+}
+
+// EpisodeVariantKey is VariantKey for the podcast of a video.
+func EpisodeVariantKey(youtubeVideoId string, categories string, mode string) string {
+	return VariantKey(episodePodcast(youtubeVideoId), categories, mode)
+}
+
+// markPrefix starts the key of a variant that marks its categories.
+const markPrefix = "mark-"
+
+// MarkKey returns the key of the variant of an episode that keeps the
+// segments of the given categories and marks them with chapters.
+func MarkKey(categories string) string {
+	return markPrefix + categories
+}
+
+// ParseVariantKey returns the categories of a variant and whether their
+// segments are marked instead of cut.
+func ParseVariantKey(key string) ([]string, bool) {
+	categories, mark := strings.CutPrefix(key, markPrefix)
+	if categories == "" {
+		return nil, mark
+	}
+	return strings.Split(categories, ","), mark
+}
+
+// categoryLabels are the chapter titles of marked segments.
+var categoryLabels = map[string]string{
+	"sponsor":        "Sponsor",
+	"selfpromo":      "Self-promo",
+	"interaction":    "Interaction reminder",
+	"intro":          "Intro",
+	"outro":          "Outro",
+	"preview":        "Preview",
+	"music_offtopic": "Non-music",
+	"filler":         "Filler",
+}
+
+// CategoryLabel returns the chapter title of the segments of a category.
+func CategoryLabel(category string) string {
+	if label, ok := categoryLabels[category]; ok {
+		return label
+	}
+	return category
+}
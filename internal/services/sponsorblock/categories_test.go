@@ -0,0 +1,83 @@
+package sponsorblock
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"slices"
+	"testing"
+)
+
+func TestParseCategories(t *testing.T) {
+	categories, err := ParseCategories(" sponsor,intro,, sponsor,selfpromo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(categories, []string{"intro", "selfpromo", "sponsor"}) {
+		t.Errorf("unexpected categories %v", categories)
+	}
+	if categories, err := ParseCategories(""); err != nil || categories != nil {
+		t.Errorf("expected no categories, got %v, %v", categories, err)
+	}
+	if _, err := ParseCategories("sponsor,ads"); err == nil {
+		t.Error("expected an unknown category to be rejected")
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	config.AppConfig.Ytdlp.SponsorBlockCategories = "sponsor, intro"
+
+	podcast := &models.Podcast{}
+	if key := VariantKey(podcast, "intro,sponsor", ""); key != "" {
+		t.Errorf("expected the configured categories to need no variant, got %q", key)
+	}
+	if key := VariantKey(podcast, "sponsor", ""); key != "sponsor" {
+		t.Errorf("unexpected key %q", key)
+	}
+
+	podcast.SponsorBlockCategories = "sponsor"
+	if key := VariantKey(podcast, "sponsor", ""); key != "" {
+		t.Errorf("expected the podcast's categories to need no variant, got %q", key)
+	}
+	if key := VariantKey(podcast, "intro,sponsor", ""); key != "intro,sponsor" {
+		t.Errorf("unexpected key %q", key)
+	}
+
+	podcast.SponsorBlockMode = MarkMode
+	if key := VariantKey(podcast, "", ""); key != "mark-sponsor" {
+		t.Errorf("expected the podcast's segments to be marked, got %q", key)
+	}
+	if key := VariantKey(podcast, "", CutMode); key != "" {
+		t.Errorf("expected the cut to be asked for explicitly, got %q", key)
+	}
+}
+
+func TestFilterTrusted(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	segments := []SponsorBlockResponse{
+		{UUID: "skip", ActionType: "skip", Votes: 3},
+		{UUID: "mute", ActionType: "mute", Votes: 3},
+		{UUID: "unvoted", ActionType: "skip", Votes: 0},
+		{UUID: "locked", ActionType: "skip", Votes: -1, Locked: 1},
+	}
+	uuids := func(segments []SponsorBlockResponse) []string {
+		var ids []string
+		for _, segment := range segments {
+			ids = append(ids, segment.UUID)
+		}
+		return ids
+	}
+
+	config.AppConfig.SponsorBlock.MinVotes = 1
+	if ids := uuids(filterTrusted(segments)); !slices.Equal(ids, []string{"skip", "locked"}) {
+		t.Errorf("unexpected segments %v", ids)
+	}
+	config.AppConfig.SponsorBlock.ActionTypes = "skip, mute"
+	if ids := uuids(filterTrusted(segments)); !slices.Equal(ids, []string{"skip", "mute", "locked"}) {
+		t.Errorf("unexpected segments %v", ids)
+	}
+	config.AppConfig.SponsorBlock.LockedOnly = true
+	if ids := uuids(filterTrusted(segments)); !slices.Equal(ids, []string{"locked"}) {
+		t.Errorf("unexpected segments %v", ids)
+	}
+}
@@ -0,0 +1,151 @@
+package sponsorblock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/models"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/labstack/gommon/log"
+)
+
+// hashPrefixLength is how many hex characters of the SHA-256 hash of a video
+// ID are sent to SponsorBlock. Four is what SponsorBlock recommends, every
+// prefix matches plenty of videos.
+const hashPrefixLength = 4
+
+// staleSegmentsMaxAge is how long cached segments are kept to fall back on
+// when SponsorBlock can't be reached.
+const staleSegmentsMaxAge = 30 * 24 * time.Hour
+
+// fetchedCategories are the categories looked up for every video. They are
+// cached together and filtered per request, so changing the configured
+// categories doesn't need another lookup.
+var fetchedCategories = []string{"sponsor", "selfpromo", "interaction", "intro", "outro", "preview", "music_offtopic", "filler"}
+
+// fetchedActionTypes are the action types looked up for every video, also
+// filtered per request.
+var fetchedActionTypes = []string{"skip", "mute", "full"}
+
+// hashPrefixResponse is one video of a hash prefix lookup.
+type hashPrefixResponse struct {
+	VideoID  string                 `json:"videoID"`
+	Segments []SponsorBlockResponse `json:"segments"`
+}
+
+// lookupSegments returns the segments of every fetched category of a video,
+// from the cache while it is fresh. When SponsorBlock can't be reached the
+// last fetched segments are used, however old.
+func lookupSegments(youtubeVideoId string) ([]SponsorBlockResponse, error) {
+	cache := database.GetSponsorSegmentCache(youtubeVideoId)
+	if cache != nil && time.Since(cache.FetchedAt) < config.SponsorBlockCacheTtl() {
+		if segments, err := unmarshalSponsorBlockResponse([]byte(cache.Segments)); err == nil {
+			return segments, nil
+		}
+	}
+
+	log.Debug("[SponsorBlock] Looking up podcast in SponsorBlock API...")
+	segments, err := fetchSegments(youtubeVideoId)
+	if err != nil {
+		if cache != nil {
+			log.Warnf("[SponsorBlock] Lookup of %s failed, using segments from %s: %v", youtubeVideoId, cache.FetchedAt.Format(time.RFC3339), err)
+			return unmarshalSponsorBlockResponse([]byte(cache.Segments))
+		}
+		return nil, err
+	}
+
+	if err := saveSegments(youtubeVideoId, segments); err != nil {
+		log.Error(err)
+	}
+	return segments, nil
+}
+
+// RefreshSegments looks the segments of a video up again, however fresh the
+// cached ones are.
+func RefreshSegments(youtubeVideoId string) error {
+	segments, err := fetchSegments(youtubeVideoId)
+	if err != nil {
+		return err
+	}
+	return saveSegments(youtubeVideoId, segments)
+}
+
+// CachedSegments returns the segments of every fetched category of a video as
+// they were last looked up, nil when they never were.
+func CachedSegments(youtubeVideoId string) []SponsorBlockResponse {
+	cache := database.GetSponsorSegmentCache(youtubeVideoId)
+	if cache == nil {
+		return nil
+	}
+	segments, err := unmarshalSponsorBlockResponse([]byte(cache.Segments))
+	if err != nil {
+		return nil
+	}
+	return segments
+}
+
+func saveSegments(youtubeVideoId string, segments []SponsorBlockResponse) error {
+	data, err := json.Marshal(segments)
+	if err != nil {
+		return err
+	}
+	return database.SaveSponsorSegmentCache(&models.SponsorSegmentCache{
+		YoutubeVideoId: youtubeVideoId,
+		Segments:       string(data),
+		FetchedAt:      time.Now(),
+	})
+}
+
+// fetchSegments looks the video up by the prefix of its hash, so
+// SponsorBlock never learns which video is being played.
+func fetchSegments(youtubeVideoId string) ([]SponsorBlockResponse, error) {
+	categories, _ := json.Marshal(fetchedCategories)
+	actionTypes, _ := json.Marshal(fetchedActionTypes)
+	endURL := config.SponsorBlockServer() + "/api/skipSegments/" + hashPrefix(youtubeVideoId) +
+		"?categories=" + url.QueryEscape(string(categories)) +
+		"&actionTypes=" + url.QueryEscape(string(actionTypes))
+
+	client := &http.Client{Timeout: config.SponsorBlockTimeout()}
+	resp, err := client.Get(endURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		// No video with this prefix has segments.
+		return []SponsorBlockResponse{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sponsorblock: unexpected status %s", resp.Status)
+	}
+
+	var videos []hashPrefixResponse
+	if err := json.NewDecoder(resp.Body).Decode(&videos); err != nil {
+		return nil, err
+	}
+	for _, video := range videos {
+		if video.VideoID == youtubeVideoId {
+			return video.Segments, nil
+		}
+	}
+	return []SponsorBlockResponse{}, nil
+}
+
+func hashPrefix(youtubeVideoId string) string {
+	hash := sha256.Sum256([]byte(youtubeVideoId))
+	return hex.EncodeToString(hash[:])[:hashPrefixLength]
+}
+
+// PruneSegmentCache deletes cached segments too old to fall back on.
+func PruneSegmentCache() {
+	if err := database.DeleteSponsorSegmentCacheBefore(time.Now().Add(-staleSegmentsMaxAge)); err != nil {
+		log.Error(err)
+	}
+}
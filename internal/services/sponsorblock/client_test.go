@@ -0,0 +1,91 @@
+package sponsorblock
+
+import (
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+)
+
+func setupTestClient(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	tmpDir := t.TempDir()
+	config.AppConfig = &config.Config{}
+	config.AppConfig.Setup.ConfigDir = tmpDir
+	config.AppConfig.Setup.DbFile = path.Join(tmpDir, "test.db")
+	config.AppConfig.Ytdlp.SponsorBlockCategories = "sponsor, intro"
+	config.AppConfig.SponsorBlock.CacheTtl = "1h"
+	database.SetupDatabase()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	config.AppConfig.SponsorBlock.Server = server.URL + "/"
+	return server
+}
+
+func TestGetSponsorSegments_HashPrefixAndCache(t *testing.T) {
+	requests := 0
+	setupTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/api/skipSegments/"+hashPrefix("video1") || strings.Contains(r.URL.String(), "video1") {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		fmt.Fprint(w, `[
+			{"videoID": "other", "segments": [{"segment": [0, 50], "category": "sponsor"}]},
+			{"videoID": "video1", "segments": [
+				{"segment": [10, 20], "category": "sponsor"},
+				{"segment": [0, 5], "category": "intro"},
+				{"segment": [30, 40], "category": "selfpromo"}
+			]}
+		]`)
+	})
+
+	for i := 0; i < 2; i++ {
+		segments := GetSponsorSegments("video1")
+		if len(segments) != 2 || segments[0].Category != "sponsor" || segments[1].Category != "intro" {
+			t.Fatalf("unexpected segments %+v", segments)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the second lookup to be cached, got %d requests", requests)
+	}
+}
+
+func TestGetSponsorSegments_FallsBackToStaleCache(t *testing.T) {
+	fail := false
+	setupTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `[{"videoID": "video1", "segments": [{"segment": [10, 20], "category": "sponsor"}]}]`)
+	})
+	config.AppConfig.SponsorBlock.CacheTtl = "0"
+
+	if segments := GetSponsorSegments("video1"); len(segments) != 1 {
+		t.Fatalf("unexpected segments %+v", segments)
+	}
+	fail = true
+	if segments := GetSponsorSegments("video1"); len(segments) != 1 {
+		t.Errorf("expected the cached segments when the lookup fails, got %+v", segments)
+	}
+	if segments := GetSponsorSegments("video2"); len(segments) != 0 {
+		t.Errorf("expected no segments without a cache, got %+v", segments)
+	}
+}
+
+func TestGetSponsorSegments_NotFound(t *testing.T) {
+	setupTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	if segments := GetSponsorSegments("video1"); len(segments) != 0 {
+		t.Errorf("expected no segments, got %+v", segments)
+	}
+	if database.GetSponsorSegmentCache("video1") == nil {
+		t.Error("expected a video without segments to be cached")
+	}
+}
@@ -4,30 +4,26 @@ import (
 	"encoding/json"
 	"ikoyhn/podcast-sponsorblock/internal/config"
 	"ikoyhn/podcast-sponsorblock/internal/database"
-	"io"
 	"math"
-	"net/http"
-	"os"
+	"slices"
+	"sort"
 	"strings"
 
 	log "github.com/labstack/gommon/log"
 )
 
-const SPONSORBLOCK_API_URL = "https://sponsor.ajay.app/api/skipSegments?videoID="
-
+// DeterminePodcastDownload reports whether the cached files of a video were
+// cut with segments that have changed since, along with the time the current
+// segments skip. Files that were never cut aren't reported.
 func DeterminePodcastDownload(youtubeVideoId string) (bool, float64) {
 	episodeHistory := database.GetEpisodePlaybackHistory(youtubeVideoId)
 
 	updatedSkippedTime := TotalSponsorTimeSkipped(youtubeVideoId)
-	if episodeHistory == nil {
-		return true, updatedSkippedTime
+	if episodeHistory.YoutubeVideoId == "" {
+		return false, updatedSkippedTime
 	}
 
-	if math.Abs(episodeHistory.TotalTimeSkipped-updatedSkippedTime) > 2 {
-		file := database.FindFileWithId(config.AppConfig.Setup.AudioDir, youtubeVideoId)
-		if file != "" {
-			os.Remove(file)
-		}
+	if SegmentsChanged(episodeHistory.TotalTimeSkipped, updatedSkippedTime) {
 		log.Debug("[SponsorBlock] Updating downloaded episode with new sponsor skips...")
 		return true, updatedSkippedTime
 	}
@@ -35,42 +31,72 @@ func DeterminePodcastDownload(youtubeVideoId string) (bool, float64) {
 	return false, updatedSkippedTime
 }
 
+// SegmentsChanged reports whether the skipped time of an episode changed
+// enough for its cached files to be cut again.
+func SegmentsChanged(previousTimeSkipped float64, timeSkipped float64) bool {
+	return math.Abs(previousTimeSkipped-timeSkipped) > 2
+}
+
 func TotalSponsorTimeSkipped(youtubeVideoId string) float64 {
-	log.Debug("[SponsorBlock] Looking up podcast in SponsorBlock API...")
-	endURL := SPONSORBLOCK_API_URL + youtubeVideoId
+	return calculateSkippedTime(GetSponsorSegments(youtubeVideoId))
+}
 
-	if categories := getCategories(); categories != nil {
-		for _, category := range categories {
-			endURL += "&category=" + strings.TrimSpace(category)
-		}
-	}
+// TimeSkippedBy returns the time the trusted segments of the given categories
+// among segments skip.
+func TimeSkippedBy(segments []SponsorBlockResponse, categories []string) float64 {
+	return calculateSkippedTime(filterTrusted(filterCategories(segments, categories)))
+}
 
-	resp, err := http.Get(endURL)
+// GetSponsorSegments returns the SponsorBlock segments for the categories of
+// the video's podcast, or an empty slice when none are found or the lookup
+// fails.
+func GetSponsorSegments(youtubeVideoId string) []SponsorBlockResponse {
+	return GetSponsorSegmentsFor(youtubeVideoId, Categories(youtubeVideoId))
+}
+
+// GetSponsorSegmentsFor returns the SponsorBlock segments for the given
+// categories, or an empty slice when none are found or the lookup fails.
+func GetSponsorSegmentsFor(youtubeVideoId string, categories []string) []SponsorBlockResponse {
+	segments, err := lookupSegments(youtubeVideoId)
 	if err != nil {
-		log.Error(err)
-		return 0
+		log.Errorf("[SponsorBlock] Unable to look up %s: %v", youtubeVideoId, err)
+		return []SponsorBlockResponse{}
 	}
-	defer resp.Body.Close()
+	return filterTrusted(filterCategories(segments, categories))
+}
 
-	if resp.StatusCode == http.StatusNotFound {
-		log.Warnf("Video not found on SponsorBlock API: %s", youtubeVideoId)
-		return 0
+// filterTrusted returns the segments of the honored action types that are
+// locked, or have at least the configured votes unless only locked segments
+// are accepted. Low-quality submissions are left in the audio.
+func filterTrusted(segments []SponsorBlockResponse) []SponsorBlockResponse {
+	settings := config.AppConfig.SponsorBlock
+	actionTypes := config.SponsorBlockActionTypes()
+	trusted := []SponsorBlockResponse{}
+	for _, segment := range segments {
+		actionType := segment.ActionType
+		if actionType == "" {
+			actionType = "skip"
+		}
+		if !slices.Contains(actionTypes, actionType) {
+			continue
+		}
+		if segment.Locked == 0 && (settings.LockedOnly || int(segment.Votes) < settings.MinVotes) {
+			continue
+		}
+		trusted = append(trusted, segment)
 	}
+	return trusted
+}
 
-	body, bodyErr := io.ReadAll(resp.Body)
-	if bodyErr != nil {
-		log.Error(bodyErr)
-		return 0
-	}
-	sponsorBlockResponse, marshErr := unmarshalSponsorBlockResponse(body)
-	if marshErr != nil {
-		log.Error(marshErr)
-		return 0
+// filterCategories returns the segments of the given categories.
+func filterCategories(segments []SponsorBlockResponse, categories []string) []SponsorBlockResponse {
+	filtered := []SponsorBlockResponse{}
+	for _, segment := range segments {
+		if slices.Contains(categories, segment.Category) {
+			filtered = append(filtered, segment)
+		}
 	}
-
-	totalTimeSkipped := calculateSkippedTime(sponsorBlockResponse)
-
-	return totalTimeSkipped
+	return filtered
 }
 
 func unmarshalSponsorBlockResponse(data []byte) ([]SponsorBlockResponse, error) {
@@ -83,31 +109,67 @@ func unmarshalSponsorBlockResponse(data []byte) ([]SponsorBlockResponse, error)
 	return res, nil
 }
 
+// calculateSkippedTime returns the time cut from a video by the segments,
+// counting overlapping segments once.
 func calculateSkippedTime(segments []SponsorBlockResponse) float64 {
 	skippedTime := float64(0)
-	prevStopTime := float64(0)
-
-	for _, segment := range segments {
-		startTime := segment.Segment[0]
-		stopTime := segment.Segment[1]
+	for _, segment := range MergeSegments(segments) {
+		skippedTime += segment[1] - segment[0]
+	}
+	return skippedTime
+}
 
-		if startTime > prevStopTime {
-			skippedTime += stopTime - startTime
-		} else {
-			skippedTime += stopTime - prevStopTime
+// MapToCutTime converts a timestamp in the original video to the matching
+// timestamp in the audio once the given segments have been removed. A
+// timestamp that falls inside a removed segment maps to where that segment
+// was cut.
+func MapToCutTime(timestamp float64, segments []SponsorBlockResponse) float64 {
+	removed := float64(0)
+	for _, segment := range MergeSegments(segments) {
+		if segment[0] >= timestamp {
+			break
 		}
+		removed += math.Min(segment[1], timestamp) - segment[0]
+	}
+	return timestamp - removed
+}
 
-		prevStopTime = stopTime
+// MergeSegments returns the [start, stop] ranges of the segments sorted by
+// start time with overlapping ranges joined together.
+func MergeSegments(segments []SponsorBlockResponse) [][2]float64 {
+	ranges := make([][2]float64, 0, len(segments))
+	for _, segment := range segments {
+		if len(segment.Segment) < 2 || segment.Segment[1] <= segment.Segment[0] {
+			continue
+		}
+		ranges = append(ranges, [2]float64{segment.Segment[0], segment.Segment[1]})
 	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
 
-	return skippedTime
+	merged := make([][2]float64, 0, len(ranges))
+	for _, r := range ranges {
+		if last := len(merged) - 1; last >= 0 && r[0] <= merged[last][1] {
+			merged[last][1] = math.Max(merged[last][1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
 }
 
+// getCategories returns the configured categories, only sponsors when none
+// are configured.
 func getCategories() []string {
-	if config.AppConfig.Ytdlp.SponsorBlockCategories == "" {
-		return nil
+	var categories []string
+	for _, category := range strings.Split(config.AppConfig.Ytdlp.SponsorBlockCategories, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			categories = append(categories, category)
+		}
+	}
+	if len(categories) == 0 {
+		return []string{"sponsor"}
 	}
-	return strings.Split(config.AppConfig.Ytdlp.SponsorBlockCategories, ",")
+	return categories
 }
 
 type SponsorBlockResponse struct {
@@ -0,0 +1,19 @@
+package sponsorblock
+
+import "testing"
+
+func TestCalculateSkippedTime(t *testing.T) {
+	// Out of order, with one segment inside another and one overlapping.
+	segments := []SponsorBlockResponse{
+		{Segment: []float64{100, 130}},
+		{Segment: []float64{10, 40}},
+		{Segment: []float64{20, 30}},
+		{Segment: []float64{35, 50}},
+	}
+	if skipped := calculateSkippedTime(segments); skipped != 70 {
+		t.Errorf("expected 70s skipped, got %v", skipped)
+	}
+	if skipped := calculateSkippedTime(nil); skipped != 0 {
+		t.Errorf("expected nothing skipped, got %v", skipped)
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Filesystem keeps the files where they were downloaded.
+type Filesystem struct{}
+
+func (Filesystem) List(dir string, prefix string) ([]File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) || common.IsPartialFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, File{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+func (Filesystem) Open(path string) (io.ReadSeekCloser, File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, File{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, File{}, err
+	}
+	return file, File{Name: filepath.Base(path), Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (Filesystem) Store(path string) error {
+	return nil
+}
+
+func (Filesystem) Fetch(path string) (bool, error) {
+	return false, nil
+}
+
+func (Filesystem) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (Filesystem) URL(path string) (string, error) {
+	return "", nil
+}
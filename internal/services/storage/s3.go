@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"ikoyhn/podcast-sponsorblock/internal/services/common"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/labstack/gommon/log"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 keeps the files in an S3 compatible bucket, e.g. AWS S3 or MinIO, under
+// keys mirroring their path below the config directory. Files are downloaded
+// and processed locally and uploaded once finished; until then the local file
+// takes precedence over the stored one.
+type S3 struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	root     string
+	redirect bool
+	expiry   time.Duration
+	local    Filesystem
+}
+
+// NewS3 connects to the configured bucket.
+func NewS3() (*S3, error) {
+	settings := config.AppConfig.Storage.S3
+	options := &minio.Options{
+		Creds:  credentials.NewStaticV4(settings.AccessKey, settings.SecretKey, ""),
+		Secure: settings.UseSSL,
+		Region: settings.Region,
+	}
+	if settings.PathStyle {
+		options.BucketLookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(settings.Endpoint, options)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := client.BucketExists(context.Background(), settings.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("s3: bucket %q does not exist", settings.Bucket)
+	}
+
+	root, err := filepath.Abs(config.AppConfig.Setup.ConfigDir)
+	if err != nil {
+		return nil, err
+	}
+	expiry, _ := time.ParseDuration(settings.PresignExpiry)
+	return &S3{
+		client:   client,
+		bucket:   settings.Bucket,
+		prefix:   normalizePrefix(settings.Prefix),
+		root:     root,
+		redirect: settings.Redirect,
+		expiry:   expiry,
+	}, nil
+}
+
+func (s *S3) List(dir string, prefix string) ([]File, error) {
+	files, err := s.local.List(dir, prefix)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, file := range files {
+		seen[file.Name] = true
+	}
+
+	dirKey, err := s.key(dir)
+	if err != nil {
+		return nil, err
+	}
+	dirKey += "/"
+	for object := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: dirKey + prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		name := strings.TrimPrefix(object.Key, dirKey)
+		// Keys in subdirectories, e.g. audio profiles, come back as prefixes.
+		if strings.HasSuffix(name, "/") || seen[name] {
+			continue
+		}
+		files = append(files, File{Name: name, Size: object.Size, ModTime: object.LastModified})
+	}
+	return files, nil
+}
+
+func (s *S3) Open(path string) (io.ReadSeekCloser, File, error) {
+	if file, info, err := s.local.Open(path); err == nil {
+		return file, info, nil
+	}
+	key, err := s.key(path)
+	if err != nil {
+		return nil, File{}, err
+	}
+	object, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, File{}, err
+	}
+	stat, err := object.Stat()
+	if err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+			return nil, File{}, os.ErrNotExist
+		}
+		return nil, File{}, err
+	}
+	return object, File{Name: filepath.Base(path), Size: stat.Size, ModTime: stat.LastModified}, nil
+}
+
+// Store uploads the local file and deletes it. A file that isn't local
+// anymore has already been stored.
+func (s *S3) Store(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	key, err := s.key(path)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.FPutObject(context.Background(), s.bucket, key, path, minio.PutObjectOptions{
+		ContentType: common.MediaTypeFromExtension(filepath.Ext(path)),
+	})
+	if err != nil {
+		return fmt.Errorf("s3: upload %s: %w", key, err)
+	}
+	log.Debugf("[STORAGE] Uploaded %s", key)
+	return os.Remove(path)
+}
+
+// Fetch downloads the stored file to its local path, through a partial file
+// so an interrupted download never looks finished.
+func (s *S3) Fetch(path string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	key, err := s.key(path)
+	if err != nil {
+		return false, err
+	}
+	object, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer object.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	partPath := path + ".part"
+	file, err := os.Create(partPath)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(file, object)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return false, fmt.Errorf("s3: download %s: %w", key, err)
+	}
+	return true, os.Rename(partPath, path)
+}
+
+// Remove deletes the stored file along with any local copy.
+func (s *S3) Remove(path string) error {
+	key, err := s.key(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.client.RemoveObject(context.Background(), s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// URL returns a presigned URL of the stored file when redirects are enabled.
+func (s *S3) URL(path string) (string, error) {
+	if !s.redirect {
+		return "", nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		// Not uploaded yet.
+		return "", nil
+	}
+	key, err := s.key(path)
+	if err != nil {
+		return "", err
+	}
+	u, err := s.client.PresignedGetObject(context.Background(), s.bucket, key, s.expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// key returns the object key of a path below the config directory.
+func (s *S3) key(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(s.root, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("s3: %s is outside of %s", path, s.root)
+	}
+	return s.prefix + filepath.ToSlash(rel), nil
+}
+
+// normalizePrefix turns a configured key prefix into one ending with a
+// single slash, or "" for none.
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
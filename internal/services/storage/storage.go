@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/labstack/gommon/log"
+)
+
+// File is a finished file kept in storage.
+type File struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage keeps the downloaded episode files. Files are addressed by the
+// local path they are downloaded and processed at, under the config
+// directory, and the backend decides where they are kept once finished.
+// Files still being written are never listed.
+type Storage interface {
+	// List returns the finished files in dir whose names start with prefix.
+	List(dir string, prefix string) ([]File, error)
+	// Open opens a finished file for reading.
+	Open(path string) (io.ReadSeekCloser, File, error)
+	// Store hands a finished local file over to the backend.
+	Store(path string) error
+	// Fetch makes the file available at its local path, reporting whether it
+	// had to be copied there.
+	Fetch(path string) (bool, error)
+	// Remove deletes a file.
+	Remove(path string) error
+	// URL returns the URL clients should be redirected to for the file, or
+	// "" when the app serves it itself.
+	URL(path string) (string, error)
+}
+
+var backend Storage = Filesystem{}
+
+// Setup selects the configured storage backend.
+func Setup() error {
+	switch config.AppConfig.Storage.Backend {
+	case "", "filesystem":
+		backend = Filesystem{}
+	case "s3":
+		s3, err := NewS3()
+		if err != nil {
+			return err
+		}
+		backend = s3
+	default:
+		return fmt.Errorf("unknown storage backend %q", config.AppConfig.Storage.Backend)
+	}
+	log.Infof("[STORAGE] Using %s storage", config.AppConfig.Storage.Backend)
+	return nil
+}
+
+// List returns the finished files in dir whose names start with prefix.
+func List(dir string, prefix string) ([]File, error) {
+	return backend.List(dir, prefix)
+}
+
+// Open opens a finished file for reading.
+func Open(path string) (io.ReadSeekCloser, File, error) {
+	return backend.Open(path)
+}
+
+// Store hands a finished local file over to the storage backend.
+func Store(path string) error {
+	return backend.Store(path)
+}
+
+// fetchedFile is a stored file made available locally for the jobs using it.
+type fetchedFile struct {
+	mu     sync.Mutex
+	users  int
+	copied bool
+}
+
+var (
+	fetchMu sync.Mutex
+	fetches = map[string]*fetchedFile{}
+)
+
+// Fetch makes a stored file available at its local path for processing. The
+// returned func removes the local copy again if one had to be made, once
+// every job that fetched it has released it.
+func Fetch(path string) (func(), error) {
+	fetchMu.Lock()
+	file, ok := fetches[path]
+	if !ok {
+		file = &fetchedFile{}
+		fetches[path] = file
+	}
+	file.users++
+	fetchMu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			fetchMu.Lock()
+			defer fetchMu.Unlock()
+			file.users--
+			if file.users > 0 {
+				return
+			}
+			delete(fetches, path)
+			if file.copied {
+				os.Remove(path)
+			}
+		})
+	}
+
+	// Also run when another job fetched the file already, since Store may
+	// have removed its copy since.
+	file.mu.Lock()
+	copied, err := backend.Fetch(path)
+	file.copied = file.copied || copied
+	file.mu.Unlock()
+	if err != nil {
+		release()
+		return func() {}, err
+	}
+	return release, nil
+}
+
+// Remove deletes a file.
+func Remove(path string) error {
+	return backend.Remove(path)
+}
+
+// URL returns the URL clients should be redirected to for a file, or "" when
+// the app serves it itself.
+func URL(path string) (string, error) {
+	return backend.URL(path)
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"ikoyhn/podcast-sponsorblock/internal/config"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilesystemList(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"abc.m4a", "abc.m4a.part", "abd.opus", "other.m4a"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("audio"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "abc.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Filesystem{}.List(dir, "ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "abc.m4a" || files[1].Name != "abd.opus" || files[0].Size != 5 {
+		t.Errorf("unexpected files %+v", files)
+	}
+}
+
+func TestS3Key(t *testing.T) {
+	s := &S3{root: "/config", prefix: normalizePrefix("/cleancast/")}
+	key, err := s.key("/config/audio/profiles/mp3/abc.mp3")
+	if err != nil || key != "cleancast/audio/profiles/mp3/abc.mp3" {
+		t.Errorf("unexpected key %q, %v", key, err)
+	}
+	for _, path := range []string{"/config", "/tmp/abc.m4a", "/config/../abc.m4a"} {
+		if _, err := s.key(path); err == nil {
+			t.Errorf("expected %s to be rejected", path)
+		}
+	}
+}
+
+// TestS3RoundTrip runs against a real bucket, e.g. a local MinIO, when
+// S3_TEST_ENDPOINT and S3_TEST_BUCKET are set.
+func TestS3RoundTrip(t *testing.T) {
+	endpoint, bucket := os.Getenv("S3_TEST_ENDPOINT"), os.Getenv("S3_TEST_BUCKET")
+	if endpoint == "" || bucket == "" {
+		t.Skip("S3_TEST_ENDPOINT and S3_TEST_BUCKET not set")
+	}
+	config.AppConfig = &config.Config{}
+	config.AppConfig.Setup.ConfigDir = t.TempDir()
+	settings := &config.AppConfig.Storage.S3
+	settings.Endpoint = endpoint
+	settings.Bucket = bucket
+	settings.AccessKey = os.Getenv("S3_TEST_ACCESS_KEY")
+	settings.SecretKey = os.Getenv("S3_TEST_SECRET_KEY")
+	settings.Prefix = "test"
+	settings.PathStyle = true
+	settings.Redirect = true
+	settings.PresignExpiry = "5m"
+	s, err := NewS3()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(config.AppConfig.Setup.ConfigDir, "audio")
+	path := filepath.Join(dir, "abc.m4a")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Remove(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the local file to be removed once stored")
+	}
+
+	files, err := s.List(dir, "abc.")
+	if err != nil || len(files) != 1 || files[0].Size != 5 {
+		t.Fatalf("unexpected files %+v, %v", files, err)
+	}
+	file, _, err := s.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(file)
+	file.Close()
+	if string(data) != "audio" {
+		t.Errorf("unexpected content %q", data)
+	}
+	if url, err := s.URL(path); err != nil || url == "" {
+		t.Errorf("expected a presigned URL, got %q, %v", url, err)
+	}
+
+	fetched, err := s.Fetch(path)
+	if err != nil || !fetched {
+		t.Fatalf("expected the file to be fetched, got %v, %v", fetched, err)
+	}
+	if err := s.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := s.List(dir, "abc."); len(files) != 0 {
+		t.Errorf("expected no files after removal, got %+v", files)
+	}
+}
+
+// copyingStorage is a backend that keeps nothing locally, so every fetch
+// makes a copy.
+type copyingStorage struct {
+	Filesystem
+}
+
+func (copyingStorage) Fetch(path string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	return true, os.WriteFile(path, []byte("audio"), 0644)
+}
+
+func TestFetch_KeepsCopyUntilLastRelease(t *testing.T) {
+	defer func(previous Storage) { backend = previous }(backend)
+	backend = copyingStorage{}
+	path := filepath.Join(t.TempDir(), "abc.m4a")
+
+	releaseFirst, err := Fetch(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseSecond, err := Fetch(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	releaseFirst()
+	releaseFirst()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the copy to be kept for the second job: %v", err)
+	}
+	releaseSecond()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the copy to be removed after the last release")
+	}
+}
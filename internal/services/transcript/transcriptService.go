@@ -0,0 +1,197 @@
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"ikoyhn/podcast-sponsorblock/internal/database"
+	"ikoyhn/podcast-sponsorblock/internal/services/downloader"
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/labstack/gommon/log"
+)
+
+// Format is the file format a transcript is served in.
+type Format string
+
+const (
+	VTT Format = "vtt"
+	SRT Format = "srt"
+)
+
+// Cue is a single caption. Start and End are in seconds.
+type Cue struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+var (
+	cueTimingRegex = regexp.MustCompile(`^((?:\d+:)?\d{1,2}:\d{2}[.,]\d{3})\s+-->\s+((?:\d+:)?\d{1,2}:\d{2}[.,]\d{3})`)
+	cueTagRegex    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// BuildTranscript returns the captions of an episode in the requested format,
+// shifted to line up with the audio served by /media, i.e. with the
+// SponsorBlock segments removed and the podcast's audio processing applied.
+// With the key of a variant they line up with that variant of the audio
+// instead, which isn't processed; a variant marking its segments is uncut.
+// It returns nil when the video has no captions.
+func BuildTranscript(youtubeVideoId string, format Format, variant string) ([]byte, error) {
+	if _, err := database.GetEpisodeByVideoId(youtubeVideoId); err != nil {
+		return nil, err
+	}
+
+	filePath := downloader.GetSubtitles(youtubeVideoId)
+	if filePath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("[TRANSCRIPT] Shifting captions for removed segments...")
+	var cues []Cue
+	categories, mark := sponsorblock.ParseVariantKey(variant)
+	switch {
+	case mark:
+		cues = ParseVTT(data)
+	case variant != "":
+		cues = ShiftCues(ParseVTT(data), sponsorblock.GetSponsorSegmentsFor(youtubeVideoId, categories))
+	default:
+		cues = ShiftCues(ParseVTT(data), sponsorblock.GetSponsorSegments(youtubeVideoId))
+		cues = RetimeCues(cues, database.ProcessedTimeMapper(youtubeVideoId))
+	}
+	if format == SRT {
+		return FormatSRT(cues), nil
+	}
+	return FormatVTT(cues), nil
+}
+
+// ParseVTT reads the cues of a WebVTT file.
+//
+// Styling tags are removed. YouTube's auto-generated captions repeat the
+// previous line at the top of every cue so it keeps scrolling on screen;
+// those repeated lines are dropped, leaving each line of text once.
+func ParseVTT(data []byte) []Cue {
+	var cues []Cue
+	var current *Cue
+	lastLine := ""
+
+	flush := func() {
+		if current != nil && current.Text != "" && current.End > current.Start {
+			cues = append(cues, *current)
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// Only an empty line ends a cue. YouTube puts lines holding just a
+		// space inside its auto-generated cues.
+		raw := strings.TrimRight(scanner.Text(), "\r")
+		if raw == "" {
+			flush()
+			continue
+		}
+		line := strings.TrimSpace(raw)
+		if match := cueTimingRegex.FindStringSubmatch(line); match != nil {
+			flush()
+			current = &Cue{Start: parseCueTime(match[1]), End: parseCueTime(match[2])}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		text := strings.TrimSpace(cueTagRegex.ReplaceAllString(line, ""))
+		text = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ").Replace(text)
+		if text == "" || text == lastLine {
+			continue
+		}
+		lastLine = text
+		if current.Text != "" {
+			current.Text += "\n"
+		}
+		current.Text += text
+	}
+	flush()
+	return cues
+}
+
+// ShiftCues moves each cue back by the segments removed before it. Cues that
+// fall entirely inside a removed segment are dropped.
+func ShiftCues(cues []Cue, segments []sponsorblock.SponsorBlockResponse) []Cue {
+	shifted := make([]Cue, 0, len(cues))
+	for _, cue := range cues {
+		cue.Start = sponsorblock.MapToCutTime(cue.Start, segments)
+		cue.End = sponsorblock.MapToCutTime(cue.End, segments)
+		if cue.End <= cue.Start {
+			continue
+		}
+		shifted = append(shifted, cue)
+	}
+	return shifted
+}
+
+// RetimeCues converts the timings of the cues with mapTime. Cues that end up
+// empty, because they covered a removed silence, are dropped.
+func RetimeCues(cues []Cue, mapTime func(float64) float64) []Cue {
+	retimed := make([]Cue, 0, len(cues))
+	for _, cue := range cues {
+		cue.Start = mapTime(cue.Start)
+		cue.End = mapTime(cue.End)
+		if cue.End <= cue.Start {
+			continue
+		}
+		retimed = append(retimed, cue)
+	}
+	return retimed
+}
+
+// FormatVTT writes the cues as a WebVTT file.
+func FormatVTT(cues []Cue) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		fmt.Fprintf(&b, "\n%s --> %s\n%s\n", formatCueTime(cue.Start, "."), formatCueTime(cue.End, "."), escapeVTT(cue.Text))
+	}
+	return b.Bytes()
+}
+
+// FormatSRT writes the cues as a SubRip file.
+func FormatSRT(cues []Cue) []byte {
+	var b bytes.Buffer
+	for i, cue := range cues {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n", i+1, formatCueTime(cue.Start, ","), formatCueTime(cue.End, ","), cue.Text)
+	}
+	return b.Bytes()
+}
+
+func parseCueTime(value string) float64 {
+	parts := strings.Split(strings.Replace(value, ",", ".", 1), ":")
+	seconds := 0.0
+	for _, part := range parts {
+		n, _ := strconv.ParseFloat(part, 64)
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+func formatCueTime(seconds float64, fractionSeparator string) string {
+	millis := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", millis/3600000, millis/60000%60, millis/1000%60, fractionSeparator, millis%1000)
+}
+
+func escapeVTT(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
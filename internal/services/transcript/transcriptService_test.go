@@ -0,0 +1,62 @@
+package transcript
+
+import (
+	"testing"
+
+	"ikoyhn/podcast-sponsorblock/internal/services/sponsorblock"
+)
+
+const autoCaptions = `WEBVTT
+Kind: captions
+Language: en
+
+00:00:01.000 --> 00:00:03.000 align:start position:0%
+ 
+welcome<00:00:01.500><c> back</c><00:00:02.000><c> to</c>
+
+00:00:03.000 --> 00:00:03.010 align:start position:0%
+welcome back to
+ 
+
+00:00:03.010 --> 00:00:05.000 align:start position:0%
+welcome back to
+the<00:00:03.500><c> show</c>
+`
+
+func TestParseVTT_DropsRepeatedAutoCaptionLines(t *testing.T) {
+	cues := ParseVTT([]byte(autoCaptions))
+	if len(cues) != 2 {
+		t.Fatalf("expected 2 cues, got %d: %+v", len(cues), cues)
+	}
+	if cues[0].Text != "welcome back to" || cues[0].Start != 1 || cues[0].End != 3 {
+		t.Errorf("unexpected first cue: %+v", cues[0])
+	}
+	if cues[1].Text != "the show" || cues[1].Start != 3.01 {
+		t.Errorf("unexpected second cue: %+v", cues[1])
+	}
+}
+
+func TestShiftCues(t *testing.T) {
+	cues := []Cue{
+		{Start: 5, End: 8, Text: "before"},
+		{Start: 12, End: 18, Text: "cut"},
+		{Start: 25, End: 30, Text: "after"},
+	}
+	segments := []sponsorblock.SponsorBlockResponse{{Segment: []float64{10, 20}}}
+
+	shifted := ShiftCues(cues, segments)
+	if len(shifted) != 2 {
+		t.Fatalf("expected the cut cue to be dropped, got %+v", shifted)
+	}
+	if shifted[1].Start != 15 || shifted[1].End != 20 {
+		t.Errorf("expected after to move to 15-20, got %+v", shifted[1])
+	}
+}
+
+func TestFormatSRT(t *testing.T) {
+	got := string(FormatSRT([]Cue{{Start: 1.5, End: 3661.25, Text: "hello"}, {Start: 3662, End: 3663, Text: "world"}}))
+	want := "1\n00:00:01,500 --> 01:01:01,250\nhello\n\n2\n01:01:02,000 --> 01:01:03,000\nworld\n"
+	if got != want {
+		t.Errorf("FormatSRT() = %q, want %q", got, want)
+	}
+}
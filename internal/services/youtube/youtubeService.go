@@ -2,6 +2,7 @@ package youtube
 
 import (
 	"context"
+	"fmt"
 	"ikoyhn/podcast-sponsorblock/internal/config"
 	"ikoyhn/podcast-sponsorblock/internal/database"
 	"ikoyhn/podcast-sponsorblock/internal/enum"
@@ -28,69 +29,121 @@ func SetupYoutubeService() {
 	}
 	YtService = service
 }
+
+// GetChannelData creates the podcast from the YouTube channel metadata when
+// dbPodcast is nil, fills in the metadata of a podcast imported before it was
+// fetched, and stamps its last build date. It returns nil when the playlist
+// or channel cannot be found.
 func GetChannelData(dbPodcast *models.Podcast, channelIdentifier string, isPlaylist bool) *models.Podcast {
-	var channelCall *ytApi.ChannelsListCall
-	var channelId string
-
-	if dbPodcast == nil {
-		if isPlaylist {
-			playlistCall := YtService.Playlists.List([]string{"snippet", "status", "contentDetails"}).
-				Id(channelIdentifier)
-			playlistResponse, err := playlistCall.Do()
-			if err != nil {
-				log.Errorf("Error retrieving playlist details: %v", err)
-			}
-			if len(playlistResponse.Items) == 0 {
-				log.Errorf("Playlist not found")
+	podcastType := enum.CHANNEL
+	if isPlaylist {
+		podcastType = enum.PLAYLIST
+	}
+
+	if dbPodcast == nil || !HasMetadata(dbPodcast) {
+		if dbPodcast == nil {
+			dbPodcast = &models.Podcast{
+				Id:              channelIdentifier,
+				PodcastEpisodes: []models.PodcastEpisode{},
 			}
-			playlist := playlistResponse.Items[0]
-			channelId = playlist.Snippet.ChannelId
-		} else {
-			channelId = channelIdentifier
 		}
+		if !FillPodcastMetadata(dbPodcast, isPlaylist) {
+			return nil
+		}
+	}
+	if dbPodcast.Type == "" {
+		dbPodcast.Type = string(podcastType)
+	}
+	dbPodcast.LastBuildDate = time.Now().Format(time.RFC1123)
+	if err := database.UpdatePodcast(dbPodcast); err != nil {
+		log.Error(err)
+	}
 
-		channelCall = YtService.Channels.List([]string{"snippet", "statistics", "contentDetails"}).
-			Id(channelId)
-		channelResponse, err := channelCall.Do()
+	return dbPodcast
+}
+
+// HasMetadata reports whether the channel metadata of a podcast has been
+// fetched. Podcasts imported by earlier versions were saved before it was.
+func HasMetadata(podcast *models.Podcast) bool {
+	return podcast.PostedDate != ""
+}
+
+// FillPodcastMetadata sets the name, description and artwork of a podcast
+// from its YouTube channel, the channel of the playlist for playlists. It
+// returns false when the playlist or channel cannot be found.
+func FillPodcastMetadata(podcast *models.Podcast, isPlaylist bool) bool {
+	channelId := podcast.Id
+	if isPlaylist {
+		playlistCall := YtService.Playlists.List([]string{"snippet", "status", "contentDetails"}).
+			Id(podcast.Id)
+		playlistResponse, err := playlistCall.Do()
 		if err != nil {
-			log.Errorf("Error retrieving channel details: %v", err)
-		}
-		if len(channelResponse.Items) == 0 {
-			log.Errorf("Channel not found")
+			log.Errorf("Error retrieving playlist details: %v", err)
+			return false
 		}
-		channel := channelResponse.Items[0]
-
-		imageUrl := ""
-		if channel.Snippet.Thumbnails.Maxres != nil {
-			imageUrl = channel.Snippet.Thumbnails.Maxres.Url
-		} else if channel.Snippet.Thumbnails.Standard != nil {
-			imageUrl = channel.Snippet.Thumbnails.Standard.Url
-		} else if channel.Snippet.Thumbnails.High != nil {
-			imageUrl = channel.Snippet.Thumbnails.High.Url
-		} else if channel.Snippet.Thumbnails.Default != nil {
-			imageUrl = channel.Snippet.Thumbnails.Default.Url
+		if len(playlistResponse.Items) == 0 {
+			log.Errorf("Playlist not found")
+			return false
 		}
+		channelId = playlistResponse.Items[0].Snippet.ChannelId
+	}
 
-		dbPodcast = &models.Podcast{
-			Id:              channelIdentifier,
-			PodcastName:     channel.Snippet.Title,
-			Description:     channel.Snippet.Description,
-			ImageUrl:        imageUrl,
-			PostedDate:      channel.Snippet.PublishedAt,
-			PodcastEpisodes: []models.PodcastEpisode{},
-			ArtistName:      channel.Snippet.Title,
-			Explicit:        "false",
-		}
+	channelCall := YtService.Channels.List([]string{"snippet", "statistics", "contentDetails"}).
+		Id(channelId)
+	channelResponse, err := channelCall.Do()
+	if err != nil {
+		log.Errorf("Error retrieving channel details: %v", err)
+		return false
+	}
+	if len(channelResponse.Items) == 0 {
+		log.Errorf("Channel not found")
+		return false
+	}
+	channel := channelResponse.Items[0]
+
+	imageUrl := ""
+	if channel.Snippet.Thumbnails.Maxres != nil {
+		imageUrl = channel.Snippet.Thumbnails.Maxres.Url
+	} else if channel.Snippet.Thumbnails.Standard != nil {
+		imageUrl = channel.Snippet.Thumbnails.Standard.Url
+	} else if channel.Snippet.Thumbnails.High != nil {
+		imageUrl = channel.Snippet.Thumbnails.High.Url
+	} else if channel.Snippet.Thumbnails.Default != nil {
+		imageUrl = channel.Snippet.Thumbnails.Default.Url
 	}
-	dbPodcast.LastBuildDate = time.Now().Format(time.RFC1123)
-	database.UpdatePodcast(dbPodcast)
 
-	return dbPodcast
+	podcast.PodcastName = channel.Snippet.Title
+	podcast.Description = channel.Snippet.Description
+	podcast.ImageUrl = imageUrl
+	podcast.PostedDate = channel.Snippet.PublishedAt
+	podcast.ArtistName = channel.Snippet.Title
+	podcast.Explicit = "false"
+	return true
 }
 
-func GetVideosAndValidate(videoIdsNotSaved []string, podcastType enum.PodcastType, podcastId string) {
+// ShouldRefresh reports whether the podcast is due for a refresh from the
+// YouTube API, based on its last build date and the configured interval.
+func ShouldRefresh(dbPodcast *models.Podcast) bool {
+	if dbPodcast == nil || dbPodcast.LastBuildDate == "" {
+		return true
+	}
+	dur, err := time.ParseDuration(config.AppConfig.Setup.PodcastRefreshInterval)
+	if err != nil {
+		panic("Invalid [podcast-refresh-interval] format. Use formats like '5m', '1h', '400s'.")
+	}
+	lastBuild, err := time.Parse(time.RFC1123, dbPodcast.LastBuildDate)
+	if err == nil && time.Since(lastBuild) < dur {
+		log.Infof("[YOUTUBE API] Skipping channel update, last build date within %v", dur)
+		return false
+	}
+	return true
+}
+
+// GetVideosAndValidate saves the videos that are long enough to be episodes
+// and returns how many new episodes were saved.
+func GetVideosAndValidate(videoIdsNotSaved []string, podcastType enum.PodcastType, podcastId string) int {
 	if len(videoIdsNotSaved) == 0 {
-		return
+		return 0
 	}
 	var missingVideos []models.PodcastEpisode
 	videoCall := YtService.Videos.List([]string{"id,snippet,contentDetails"}).
@@ -100,6 +153,7 @@ func GetVideosAndValidate(videoIdsNotSaved []string, podcastType enum.PodcastTyp
 	videoResponse, err := videoCall.Do()
 	if err != nil {
 		log.Error(err)
+		return 0
 	}
 
 	dur, err := time.ParseDuration(config.AppConfig.Ytdlp.EpisodeDurationMinimum)
@@ -126,6 +180,7 @@ func GetVideosAndValidate(videoIdsNotSaved []string, podcastType enum.PodcastTyp
 	if len(missingVideos) > 0 {
 		database.SavePlaylistEpisodes(missingVideos)
 	}
+	return len(missingVideos)
 }
 
 func FindChannel(channelID string) bool {
@@ -152,3 +207,15 @@ func FindChannel(channelID string) bool {
 	}
 	return true
 }
+
+// ResolveChannelHandle returns the channel ID for a YouTube @handle.
+func ResolveChannelHandle(handle string) (string, error) {
+	channelResponse, err := YtService.Channels.List([]string{"id"}).ForHandle(handle).Do()
+	if err != nil {
+		return "", err
+	}
+	if len(channelResponse.Items) == 0 {
+		return "", fmt.Errorf("channel not found for handle %s", handle)
+	}
+	return channelResponse.Items[0].Id, nil
+}
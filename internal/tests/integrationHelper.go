@@ -25,6 +25,9 @@ func SetupIntegration(t *testing.T) {
 	config.AppConfig.Setup.ConfigDir = tmpDir
 	config.AppConfig.Setup.DbFile = path.Join(tmpDir, "test.db")
 	config.AppConfig.Setup.AudioDir = path.Join(tmpDir, "audio")
+	config.AppConfig.Setup.VideoDir = path.Join(tmpDir, "video")
+	config.AppConfig.Setup.TranscriptDir = path.Join(tmpDir, "transcripts")
+	config.AppConfig.Setup.SourceDir = path.Join(tmpDir, "source")
 	config.AppConfig.Setup.PodcastRefreshInterval = "0s"
 	config.AppConfig.Ytdlp.EpisodeDurationMinimum = "0s"
 